@@ -3,6 +3,7 @@ package articles
 import (
 	"github.com/gin-gonic/gin"
 
+	"gin-demo/models"
 	"gin-demo/session"
 )
 
@@ -13,8 +14,9 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	rg.GET(":id", GetArticle)
 	rg.GET("/labels", ListLabels)
 
-	// create, update and delete require auth
-	rg.POST("/", session.AuthRequired(), CreateArticle)
-	rg.PUT(":id", session.AuthRequired(), UpdateArticle)
-	rg.DELETE(":id", session.AuthRequired(), DeleteArticle)
+	// create, update and delete require auth and the articles:write permission
+	write := session.PermissionRequired(models.PermArticlesWrite)
+	rg.POST("/", session.AuthRequired(), write, CreateArticle)
+	rg.PUT(":id", session.AuthRequired(), write, UpdateArticle)
+	rg.DELETE(":id", session.AuthRequired(), write, DeleteArticle)
 }
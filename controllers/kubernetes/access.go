@@ -0,0 +1,46 @@
+package kubernetes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"gin-demo/models"
+)
+
+// currentUser returns the username stored by the auth middleware.
+func currentUser(c *gin.Context) string {
+	userI, _ := c.Get("user")
+	username, _ := userI.(string)
+	return username
+}
+
+// authorizeNamespace checks the namespace grants of the current user in the cluster
+// selected by the request and aborts the request with 403 when verb is not allowed
+// in namespace.
+func authorizeNamespace(c *gin.Context, namespace, verb string) bool {
+	cl, ok := clusterFor(c)
+	if !ok {
+		return false
+	}
+	return authorizeClusterNamespace(c, cl.name, namespace, verb)
+}
+
+// authorizeClusterNamespace is authorizeNamespace for a given cluster, such as the
+// one of a change request.
+func authorizeClusterNamespace(c *gin.Context, cluster, namespace, verb string) bool {
+	username := currentUser(c)
+	allowed, err := models.NamespaceAllowed(username, cluster, namespace, verb)
+	if err != nil {
+		logrus.Errorf("k8s: grant lookup failed user=%s cluster=%s ns=%s verb=%s err=%v", username, cluster, namespace, verb, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return false
+	}
+	if !allowed {
+		logrus.Warnf("k8s: denied user=%s cluster=%s ns=%s verb=%s path=%s", username, cluster, namespace, verb, c.Request.URL.Path)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "namespace access denied", "cluster": cluster, "namespace": namespace, "verb": verb})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,433 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"gin-demo/models"
+)
+
+// restartedAtAnnotation is the pod template annotation kubectl rollout restart sets.
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+var (
+	rolloutPollInterval = 2 * time.Second
+	rolloutMaxTimeout   = 30 * time.Minute
+)
+
+// workloadRequest validates ns and name and checks verb; it returns the cluster client.
+func workloadRequest(c *gin.Context, verb string) (kubernetes.Interface, string, string, bool) {
+	namespace := c.Query("ns")
+	name := c.Query("name")
+	if namespace == "" || name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
+		return nil, "", "", false
+	}
+	if !authorizeNamespace(c, namespace, verb) {
+		return nil, "", "", false
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return nil, "", "", false
+	}
+	return cs, namespace, name, true
+}
+
+// scaleWorkload sets the replicas of a deployment or statefulset through the scale
+// subresource. In a protected namespace the new replicas are filed as a patch in a
+// change request.
+func scaleWorkload(c *gin.Context, kind string) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbScale)
+	if !ok {
+		return
+	}
+	replicas, err := strconv.Atoi(c.Query("replicas"))
+	if err != nil || replicas < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "replicas must be a non-negative integer"})
+		return
+	}
+	cl, protected, ok := protectedNamespace(c, namespace)
+	if !ok {
+		return
+	}
+	if protected {
+		live, err := typedKinds[kind].client(cs, namespace).get(context.TODO(), name)
+		if err != nil {
+			c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas)
+		requestPatchChange(c, cl, kind, live, types.StrategicMergePatchType, []byte(patch))
+		return
+	}
+
+	ctx := context.TODO()
+	rec := auditFor(c)
+	var scale *autoscalingv1.Scale
+	switch kind {
+	case "deployments":
+		scale, err = cs.AppsV1().Deployments(namespace).GetScale(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			rec.before = objectHash(scale)
+			scale.Spec.Replicas = int32(replicas)
+			scale, err = cs.AppsV1().Deployments(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{FieldManager: fieldManager})
+		}
+	case "statefulsets":
+		scale, err = cs.AppsV1().StatefulSets(namespace).GetScale(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			rec.before = objectHash(scale)
+			scale.Spec.Replicas = int32(replicas)
+			scale, err = cs.AppsV1().StatefulSets(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{FieldManager: fieldManager})
+		}
+	}
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	rec.after = objectHash(scale)
+	c.JSON(http.StatusOK, gin.H{"message": "scaled", "replicas": replicas})
+}
+
+// patchObject patches name through kc and records the object hashes before and
+// after the patch in the audit entry of the request.
+func patchObject(c *gin.Context, kc kindClient, name string, pt types.PatchType, patch []byte) (runtime.Object, error) {
+	ctx := context.TODO()
+	live, err := kc.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rec := auditFor(c)
+	rec.before = objectHash(live)
+	patched, err := kc.patch(ctx, name, pt, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		return nil, err
+	}
+	rec.after = objectHash(patched)
+	return patched, nil
+}
+
+// patchWorkload patches an object of a typed kind, such as a deployment, daemonset,
+// statefulset or cronjob, answering the request on failure. In a protected namespace
+// the patch is filed as a change request instead. It reports whether the patch was
+// applied, leaving the response to the caller.
+func patchWorkload(c *gin.Context, cs kubernetes.Interface, kind, namespace, name string, pt types.PatchType, patch []byte) bool {
+	rk, ok := typedKinds[kind]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported kind %s", kind)})
+		return false
+	}
+	kc := rk.client(cs, namespace)
+	cl, protected, ok := protectedNamespace(c, namespace)
+	if !ok {
+		return false
+	}
+	if protected {
+		live, err := kc.get(context.TODO(), name)
+		if err != nil {
+			c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+			return false
+		}
+		requestPatchChange(c, cl, kind, live, pt, patch)
+		return false
+	}
+	if _, err := patchObject(c, kc, name, pt, patch); err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// restartWorkload triggers a rolling restart like kubectl rollout restart.
+func restartWorkload(c *gin.Context, kind string) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbUpdate)
+	if !ok {
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`, restartedAtAnnotation, now)
+	if !patchWorkload(c, cs, kind, namespace, name, types.StrategicMergePatchType, []byte(patch)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "restarted", "restartedAt": now})
+}
+
+// setDeploymentPaused pauses or resumes a deployment rollout.
+func setDeploymentPaused(c *gin.Context, paused bool) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbUpdate)
+	if !ok {
+		return
+	}
+	patch := fmt.Sprintf(`{"spec":{"paused":%t}}`, paused)
+	if !patchWorkload(c, cs, "deployments", namespace, name, types.StrategicMergePatchType, []byte(patch)) {
+		return
+	}
+	msg := "resumed"
+	if paused {
+		msg = "paused"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg})
+}
+
+// ScaleDeployment sets the replicas of a deployment
+func ScaleDeployment(c *gin.Context) { scaleWorkload(c, "deployments") }
+
+// ScaleStatefulSet sets the replicas of a statefulset
+func ScaleStatefulSet(c *gin.Context) { scaleWorkload(c, "statefulsets") }
+
+// RestartDeployment performs a rolling restart of a deployment
+func RestartDeployment(c *gin.Context) { restartWorkload(c, "deployments") }
+
+// RestartDaemonSet performs a rolling restart of a daemonset
+func RestartDaemonSet(c *gin.Context) { restartWorkload(c, "daemonsets") }
+
+// RestartStatefulSet performs a rolling restart of a statefulset
+func RestartStatefulSet(c *gin.Context) { restartWorkload(c, "statefulsets") }
+
+// PauseDeployment pauses the rollout of a deployment
+func PauseDeployment(c *gin.Context) { setDeploymentPaused(c, true) }
+
+// ResumeDeployment resumes a paused deployment rollout
+func ResumeDeployment(c *gin.Context) { setDeploymentPaused(c, false) }
+
+// rolloutStatus mirrors the checks of kubectl rollout status.
+type rolloutStatus struct {
+	Kind               string `json:"kind"`
+	Name               string `json:"name"`
+	Done               bool   `json:"done"`
+	Failed             bool   `json:"failed"`
+	Paused             bool   `json:"paused,omitempty"`
+	Message            string `json:"message"`
+	Generation         int64  `json:"generation"`
+	ObservedGeneration int64  `json:"observedGeneration"`
+	Desired            int32  `json:"desired"`
+	Updated            int32  `json:"updated"`
+	Ready              int32  `json:"ready"`
+	Available          int32  `json:"available"`
+	// recent events of the workload, only in non-streaming responses
+	Events []eventView `json:"events,omitempty"`
+}
+
+func deploymentRolloutStatus(d *appsv1.Deployment) rolloutStatus {
+	st := rolloutStatus{Kind: "Deployment", Name: d.Name, Paused: d.Spec.Paused,
+		Generation: d.Generation, ObservedGeneration: d.Status.ObservedGeneration,
+		Updated: d.Status.UpdatedReplicas, Ready: d.Status.ReadyReplicas, Available: d.Status.AvailableReplicas}
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	st.Desired = desired
+	if d.Generation > d.Status.ObservedGeneration {
+		st.Message = "waiting for deployment spec update to be observed"
+		return st
+	}
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			st.Failed = true
+			st.Message = fmt.Sprintf("deployment %q exceeded its progress deadline", d.Name)
+			return st
+		}
+	}
+	switch {
+	case d.Status.UpdatedReplicas < desired:
+		st.Message = fmt.Sprintf("%d out of %d new replicas have been updated", d.Status.UpdatedReplicas, desired)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		st.Message = fmt.Sprintf("%d old replicas are pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		st.Message = fmt.Sprintf("%d of %d updated replicas are available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	default:
+		st.Done = true
+		st.Message = fmt.Sprintf("deployment %q successfully rolled out", d.Name)
+	}
+	if !st.Done && d.Spec.Paused {
+		st.Message += " (rollout paused)"
+	}
+	return st
+}
+
+func daemonSetRolloutStatus(ds *appsv1.DaemonSet) rolloutStatus {
+	st := rolloutStatus{Kind: "DaemonSet", Name: ds.Name,
+		Generation: ds.Generation, ObservedGeneration: ds.Status.ObservedGeneration,
+		Desired: ds.Status.DesiredNumberScheduled, Updated: ds.Status.UpdatedNumberScheduled,
+		Ready: ds.Status.NumberReady, Available: ds.Status.NumberAvailable}
+	if ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+		st.Failed = true
+		st.Message = "rollout status is only available for RollingUpdate strategy type"
+		return st
+	}
+	switch {
+	case ds.Generation > ds.Status.ObservedGeneration:
+		st.Message = "waiting for daemon set spec update to be observed"
+	case ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled:
+		st.Message = fmt.Sprintf("%d out of %d new pods have been updated", ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled)
+	case ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled:
+		st.Message = fmt.Sprintf("%d of %d updated pods are available", ds.Status.NumberAvailable, ds.Status.DesiredNumberScheduled)
+	default:
+		st.Done = true
+		st.Message = fmt.Sprintf("daemon set %q successfully rolled out", ds.Name)
+	}
+	return st
+}
+
+func statefulSetRolloutStatus(sts *appsv1.StatefulSet) rolloutStatus {
+	st := rolloutStatus{Kind: "StatefulSet", Name: sts.Name,
+		Generation: sts.Generation, ObservedGeneration: sts.Status.ObservedGeneration,
+		Updated: sts.Status.UpdatedReplicas, Ready: sts.Status.ReadyReplicas, Available: sts.Status.AvailableReplicas}
+	desired := int32(1)
+	if sts.Spec.Replicas != nil {
+		desired = *sts.Spec.Replicas
+	}
+	st.Desired = desired
+	if sts.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		st.Failed = true
+		st.Message = "rollout status is only available for RollingUpdate strategy type"
+		return st
+	}
+	if sts.Status.ObservedGeneration == 0 || sts.Generation > sts.Status.ObservedGeneration {
+		st.Message = "waiting for statefulset spec update to be observed"
+		return st
+	}
+	if sts.Status.ReadyReplicas < desired {
+		st.Message = fmt.Sprintf("waiting for %d pods to be ready", desired-sts.Status.ReadyReplicas)
+		return st
+	}
+	if ru := sts.Spec.UpdateStrategy.RollingUpdate; ru != nil && ru.Partition != nil && *ru.Partition > 0 {
+		if sts.Status.UpdatedReplicas < desired-*ru.Partition {
+			st.Message = fmt.Sprintf("waiting for partitioned roll out to finish: %d out of %d new pods have been updated",
+				sts.Status.UpdatedReplicas, desired-*ru.Partition)
+			return st
+		}
+		st.Done = true
+		st.Message = fmt.Sprintf("partitioned roll out complete: %d new pods have been updated", sts.Status.UpdatedReplicas)
+		return st
+	}
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		st.Message = fmt.Sprintf("waiting for statefulset rolling update to complete %d pods at revision %s",
+			sts.Status.UpdatedReplicas, sts.Status.UpdateRevision)
+		return st
+	}
+	st.Done = true
+	st.Message = fmt.Sprintf("statefulset rolling update complete %d pods at revision %s", sts.Status.CurrentReplicas, sts.Status.CurrentRevision)
+	return st
+}
+
+// fetchRolloutStatus reads the workload and computes its rollout status.
+func fetchRolloutStatus(ctx context.Context, cs kubernetes.Interface, kind, namespace, name string) (rolloutStatus, error) {
+	switch kind {
+	case "deployments":
+		d, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return rolloutStatus{}, err
+		}
+		return deploymentRolloutStatus(d), nil
+	case "daemonsets":
+		ds, err := cs.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return rolloutStatus{}, err
+		}
+		return daemonSetRolloutStatus(ds), nil
+	case "statefulsets":
+		sts, err := cs.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return rolloutStatus{}, err
+		}
+		return statefulSetRolloutStatus(sts), nil
+	}
+	return rolloutStatus{}, fmt.Errorf("unsupported kind %s", kind)
+}
+
+// rolloutStatusHandler returns the current rollout status, or with watch=true streams
+// "progress" Server-Sent Events until the rollout completes ("complete"), fails
+// ("failed") or timeoutSeconds (default 600) elapses ("timeout").
+func rolloutStatusHandler(c *gin.Context, kind string) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbGet)
+	if !ok {
+		return
+	}
+	st, err := fetchRolloutStatus(context.TODO(), cs, kind, namespace, name)
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("watch") != "true" {
+		if events, err := workloadEvents(context.TODO(), cs, namespace, st.Kind, name, nil); err == nil {
+			st.Events = events
+		}
+		c.JSON(http.StatusOK, st)
+		return
+	}
+
+	timeout := 10 * time.Minute
+	if v := c.Query("timeoutSeconds"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			timeout = time.Duration(n) * time.Second
+		}
+	}
+	if timeout > rolloutMaxTimeout {
+		timeout = rolloutMaxTimeout
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	send := func(event string, data interface{}) {
+		_ = sse.Encode(c.Writer, sse.Event{Event: event, Data: data})
+		c.Writer.Flush()
+	}
+
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+	last := ""
+	for {
+		if st.Message != last {
+			send("progress", st)
+			last = st.Message
+		}
+		if st.Done {
+			send("complete", st)
+			return
+		}
+		if st.Failed {
+			send("failed", st)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			if c.Request.Context().Err() == nil {
+				send("timeout", st)
+			}
+			return
+		case <-ticker.C:
+		}
+		st, err = fetchRolloutStatus(ctx, cs, kind, namespace, name)
+		if err != nil {
+			if ctx.Err() == nil {
+				send("error", gin.H{"error": err.Error()})
+			}
+			return
+		}
+	}
+}
+
+// GetDeploymentRolloutStatus reports the rollout progress of a deployment
+func GetDeploymentRolloutStatus(c *gin.Context) { rolloutStatusHandler(c, "deployments") }
+
+// GetDaemonSetRolloutStatus reports the rollout progress of a daemonset
+func GetDaemonSetRolloutStatus(c *gin.Context) { rolloutStatusHandler(c, "daemonsets") }
+
+// GetStatefulSetRolloutStatus reports the rollout progress of a statefulset
+func GetStatefulSetRolloutStatus(c *gin.Context) { rolloutStatusHandler(c, "statefulsets") }
@@ -0,0 +1,319 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"gin-demo/models"
+)
+
+// fieldManager identifies this application in managedFields of applied objects.
+const fieldManager = "gin-demo"
+
+// ignoredDiffPaths are maintained by the apiserver and left out of previews.
+var ignoredDiffPaths = map[string]bool{
+	"metadata.managedFields":   true,
+	"metadata.resourceVersion": true,
+	"metadata.generation":      true,
+	"status":                   true,
+}
+
+// fieldChange is one entry of a structured diff between two objects.
+type fieldChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"` // add, remove or replace
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// diffValues appends the changes needed to turn old into new.
+func diffValues(path string, old, new interface{}, out *[]fieldChange) {
+	if ignoredDiffPaths[path] {
+		return
+	}
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n, ok := new.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := map[string]bool{}
+		for k := range o {
+			keys[k] = true
+		}
+		for k := range n {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			switch {
+			case !inOld:
+				if !ignoredDiffPaths[p] {
+					*out = append(*out, fieldChange{Path: p, Op: "add", New: nv})
+				}
+			case !inNew:
+				if !ignoredDiffPaths[p] {
+					*out = append(*out, fieldChange{Path: p, Op: "remove", Old: ov})
+				}
+			default:
+				diffValues(p, ov, nv, out)
+			}
+		}
+		return
+	case []interface{}:
+		n, ok := new.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(o) || i < len(n); i++ {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(o):
+				*out = append(*out, fieldChange{Path: p, Op: "add", New: n[i]})
+			case i >= len(n):
+				*out = append(*out, fieldChange{Path: p, Op: "remove", Old: o[i]})
+			default:
+				diffValues(p, o[i], n[i], out)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(old, new) {
+		*out = append(*out, fieldChange{Path: path, Op: "replace", Old: old, New: new})
+	}
+}
+
+// diffObjects returns the structured diff between two API objects.
+func diffObjects(live, proposed runtime.Object) ([]fieldChange, error) {
+	lu, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return nil, err
+	}
+	pu, err := runtime.DefaultUnstructuredConverter.ToUnstructured(proposed)
+	if err != nil {
+		return nil, err
+	}
+	changes := []fieldChange{}
+	diffValues("", lu, pu, &changes)
+	return changes, nil
+}
+
+// decodeApplyBody turns user YAML or JSON into an apply patch for the object identified
+// by gvk, namespace and name (empty for cluster-scoped objects). Server-populated
+// metadata and status are dropped; a resourceVersion is kept so the apply fails when
+// the object changed meanwhile.
+func decodeApplyBody(doc string, gvk schema.GroupVersionKind, namespace, name string) ([]byte, string, error) {
+	jsonData, err := sigsyaml.YAMLToJSON([]byte(doc))
+	if err != nil {
+		return nil, "", err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(jsonData, &obj); err != nil || obj == nil {
+		return nil, "", errors.New("yaml must describe a single object")
+	}
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	if v, ok := obj["apiVersion"].(string); ok && v != "" && v != apiVersion {
+		return nil, "", fmt.Errorf("apiVersion %q does not match %q", v, apiVersion)
+	}
+	if v, ok := obj["kind"].(string); ok && v != "" && v != kind {
+		return nil, "", fmt.Errorf("kind %q does not match %q", v, kind)
+	}
+	obj["apiVersion"] = apiVersion
+	obj["kind"] = kind
+
+	md, _ := obj["metadata"].(map[string]interface{})
+	if md == nil {
+		md = map[string]interface{}{}
+		obj["metadata"] = md
+	}
+	if v, ok := md["name"].(string); ok && v != "" && v != name {
+		return nil, "", fmt.Errorf("metadata.name %q does not match %q", v, name)
+	}
+	if v, ok := md["namespace"].(string); ok && v != "" && v != namespace {
+		return nil, "", fmt.Errorf("metadata.namespace %q does not match %q", v, namespace)
+	}
+	md["name"] = name
+	if namespace != "" {
+		md["namespace"] = namespace
+	}
+	for _, k := range []string{"managedFields", "creationTimestamp", "generation", "uid", "selfLink"} {
+		delete(md, k)
+	}
+	delete(obj, "status")
+	rv, _ := md["resourceVersion"].(string)
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, rv, nil
+}
+
+// writeApplyError reports apply failures, spelling out conflicts.
+func writeApplyError(c *gin.Context, err error) {
+	var se *apierrors.StatusError
+	if apierrors.IsConflict(err) && errors.As(err, &se) {
+		var conflicts []gin.H
+		if d := se.ErrStatus.Details; d != nil {
+			for _, cause := range d.Causes {
+				if cause.Type == metav1.CauseTypeFieldManagerConflict {
+					conflicts = append(conflicts, gin.H{"field": cause.Field, "message": cause.Message})
+				}
+			}
+		}
+		if len(conflicts) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     "fields are owned by another manager",
+				"conflicts": conflicts,
+				"hint":      "re-submit with force=true to take ownership of these fields",
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+			"hint":  "the object was modified after it was loaded; reload it and re-apply your changes",
+		})
+		return
+	}
+	c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+}
+
+// applyYAML implements the save flow of the Update* handlers, see applyChange.
+func applyYAML(c *gin.Context, kind string) {
+	namespace := c.Query("ns")
+	name := c.Query("name")
+	yamlStr := c.PostForm("yaml")
+	if namespace == "" || name == "" || yamlStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace, name and yaml required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbUpdate) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+	rk := typedKinds[kind]
+	applyChange(c, kind, rk.client(cs, namespace), rk.gvk, namespace, name, yamlStr, nil)
+}
+
+// applyChange applies yamlStr to the object name through kc. Without confirm=true it
+// runs a server-side dry-run apply and returns the diff between the live object and
+// the result; with confirm=true it applies the change, or files a change request when
+// the namespace is protected. force=true takes ownership of fields managed by someone
+// else. redact, when set, hides sensitive values in the diff. resource names the kind
+// for change requests, see changeClient.
+func applyChange(c *gin.Context, resource string, kc kindClient, gvk schema.GroupVersionKind, namespace, name, yamlStr string, redact func([]fieldChange)) {
+	body, rv, err := decodeApplyBody(yamlStr, gvk, namespace, name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.TODO()
+	live, err := kc.get(ctx, name)
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	liveMeta, err := meta.Accessor(live)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rv != "" && rv != liveMeta.GetResourceVersion() {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":               "resourceVersion conflict: the object changed after it was loaded",
+			"yourResourceVersion": rv,
+			"liveResourceVersion": liveMeta.GetResourceVersion(),
+			"hint":                "reload the object and re-apply your changes",
+		})
+		return
+	}
+
+	force := c.Query("force") == "true"
+	opts := metav1.PatchOptions{FieldManager: fieldManager, Force: &force}
+	dryRunOpts := opts
+	dryRunOpts.DryRun = []string{metav1.DryRunAll}
+	proposed, err := kc.patch(ctx, name, types.ApplyPatchType, body, dryRunOpts)
+	if err != nil {
+		writeApplyError(c, err)
+		return
+	}
+	diff, err := diffObjects(live, proposed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if redact != nil {
+		redact(diff)
+	}
+
+	if c.Query("confirm") != "true" {
+		skipAudit(c)
+		c.JSON(http.StatusOK, gin.H{"dryRun": true, "diff": diff, "resourceVersion": liveMeta.GetResourceVersion()})
+		return
+	}
+	if namespace != "" {
+		cl, protected, ok := protectedNamespace(c, namespace)
+		if !ok {
+			return
+		}
+		if protected {
+			apiVersion, kind := gvk.ToAPIVersionAndKind()
+			requestChange(c, &models.ChangeRequest{
+				Cluster:         cl.name,
+				Namespace:       namespace,
+				Resource:        resource,
+				APIVersion:      apiVersion,
+				Kind:            kind,
+				Name:            name,
+				YAML:            yamlStr,
+				ResourceVersion: liveMeta.GetResourceVersion(),
+				Force:           force,
+			}, diff)
+			return
+		}
+	}
+
+	rec := auditFor(c)
+	rec.kind = gvk.Kind
+	rec.before = objectHash(live)
+	if detail, err := json.Marshal(diff); err == nil {
+		rec.detail = string(detail)
+	}
+	applied, err := kc.patch(ctx, name, types.ApplyPatchType, body, opts)
+	if err != nil {
+		writeApplyError(c, err)
+		return
+	}
+	rec.after = objectHash(applied)
+	appliedMeta, _ := meta.Accessor(applied)
+	c.JSON(http.StatusOK, gin.H{"message": "updated", "diff": diff, "resourceVersion": appliedMeta.GetResourceVersion()})
+}
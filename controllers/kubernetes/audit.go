@@ -0,0 +1,312 @@
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"gin-demo/models"
+)
+
+// auditContextKey holds the *auditRecord of a mutating request.
+const auditContextKey = "k8sAudit"
+
+var (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	// CSV exports are not paginated but capped
+	maxAuditExport = 10000
+	// error bodies are captured up to this size to fill in the result
+	auditBodyLimit = 4096
+)
+
+// kinds of the non-typed resources in audit entries
+var auditKinds = map[string]string{
+	"nodes":     "Node",
+	"clusters":  "Cluster",
+	"manifests": "Manifest",
+	"protected": "ProtectedNamespace",
+	"changes":   "ChangeRequest",
+}
+
+// auditRecord collects what a mutating handler did. The middleware fills it from the
+// route and query; handlers may refine it.
+type auditRecord struct {
+	skip      bool
+	cluster   string
+	namespace string
+	kind      string
+	name      string
+	action    string
+	// hashes of the object before and after the change; before is empty for
+	// created objects and after for deleted ones
+	before string
+	after  string
+	detail string
+}
+
+// auditFor returns the audit record of the request, or a throwaway one for routes
+// that are not audited so that handlers need not check.
+func auditFor(c *gin.Context) *auditRecord {
+	if v, ok := c.Get(auditContextKey); ok {
+		return v.(*auditRecord)
+	}
+	return &auditRecord{}
+}
+
+// skipAudit drops the audit entry of a request that turned out not to change
+// anything, such as a preview or a dry-run.
+func skipAudit(c *gin.Context) {
+	auditFor(c).skip = true
+}
+
+// objectHash returns the sha256 of the JSON form of obj.
+func objectHash(obj interface{}) string {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditWriter keeps the start of the response body to report errors.
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *auditWriter) capture(b []byte) {
+	if room := auditBodyLimit - w.body.Len(); room > 0 {
+		if len(b) > room {
+			b = b[:room]
+		}
+		w.body.Write(b)
+	}
+}
+
+// result is "ok", or "error: <message>" like the exec audit entries.
+func (w *auditWriter) result() string {
+	status := w.Status()
+	if status < http.StatusBadRequest && status != http.StatusMultiStatus {
+		return "ok"
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	msg := http.StatusText(status)
+	if json.Unmarshal(w.body.Bytes(), &body) == nil && body.Error != "" {
+		msg = body.Error
+	} else if status == http.StatusMultiStatus {
+		msg = "partial failure"
+	}
+	result := "error: " + msg
+	if len(result) > 255 {
+		result = result[:255]
+	}
+	return result
+}
+
+// auditClusterName returns the cluster a request targets without failing the request.
+func auditClusterName(c *gin.Context) string {
+	if name := c.Query("cluster"); name != "" {
+		return name
+	}
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+	return defaultCluster
+}
+
+// newAuditRecord derives kind and action from the route: /<kind> is create (POST) or
+// delete (DELETE), /<kind>/<action> names the action and the generic resource
+// routes use their resource parameter.
+func newAuditRecord(c *gin.Context, prefix string) *auditRecord {
+	rec := &auditRecord{
+		cluster:   auditClusterName(c),
+		namespace: c.Query("ns"),
+		name:      c.Query("name"),
+		detail:    c.Request.URL.RawQuery,
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(c.FullPath(), prefix), "/"), "/")
+	resource := segments[0]
+	switch {
+	case resource == "resources":
+		resource = c.Param("resource")
+		rec.action = segments[len(segments)-1]
+	case len(segments) == 1 || strings.HasPrefix(segments[len(segments)-1], ":"):
+		rec.action = "create"
+		if c.Request.Method == http.MethodDelete {
+			rec.action = "delete"
+		}
+	default:
+		rec.action = segments[len(segments)-1]
+	}
+	rec.kind = resource
+	if rk, ok := typedKinds[resource]; ok {
+		rec.kind = rk.gvk.Kind
+	} else if kind, ok := auditKinds[resource]; ok {
+		rec.kind = kind
+	}
+	if resource == "clusters" {
+		rec.name = c.Param("name")
+	}
+	return rec
+}
+
+// auditMutations writes an audit entry for every request that is not a read, with
+// the user, target, result and client IP. prefix is the path of the route group.
+func auditMutations(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		rec := newAuditRecord(c, prefix)
+		c.Set(auditContextKey, rec)
+		w := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		c.Next()
+
+		if rec.skip {
+			return
+		}
+		entry := &models.AuditLog{
+			User:       currentUser(c),
+			Cluster:    rec.cluster,
+			Namespace:  rec.namespace,
+			Kind:       rec.kind,
+			Name:       rec.name,
+			Action:     rec.action,
+			Result:     w.result(),
+			Status:     w.Status(),
+			ClientIP:   c.ClientIP(),
+			BeforeHash: rec.before,
+			AfterHash:  rec.after,
+			Detail:     rec.detail,
+		}
+		if err := models.CreateAuditLog(entry); err != nil {
+			logrus.Errorf("k8s: audit write failed user=%s action=%s %s %s/%s: %v",
+				entry.User, entry.Action, entry.Kind, entry.Namespace, entry.Name, err)
+		}
+	}
+}
+
+// parseAuditFilter reads the audit query parameters; it answers 400 on bad input.
+func parseAuditFilter(c *gin.Context) (models.AuditFilter, bool) {
+	f := models.AuditFilter{
+		User:      c.Query("user"),
+		Cluster:   c.Query("cluster"),
+		Namespace: c.Query("ns"),
+		Kind:      c.Query("kind"),
+		Name:      c.Query("name"),
+		Action:    c.Query("action"),
+		Limit:     defaultAuditLimit,
+	}
+	switch c.Query("result") {
+	case "":
+	case "ok":
+		failed := false
+		f.Failed = &failed
+	case "error":
+		failed := true
+		f.Failed = &failed
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "result must be ok or error"})
+		return f, false
+	}
+	for _, p := range []struct {
+		key string
+		dst *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if v := c.Query(p.key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.key + " must be an RFC 3339 time"})
+				return f, false
+			}
+			*p.dst = t
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return f, false
+		}
+		f.Limit = n
+	}
+	if f.Limit > maxAuditLimit {
+		f.Limit = maxAuditLimit
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return f, false
+		}
+		f.Offset = n
+	}
+	return f, true
+}
+
+// GetAuditLogs lists kubernetes audit entries, newest first. Filters: user, cluster,
+// ns, kind, name, action, result (ok or error), since and until (RFC 3339); paging
+// through limit (default 100) and offset. format=csv downloads every match.
+func GetAuditLogs(c *gin.Context) {
+	f, ok := parseAuditFilter(c)
+	if !ok {
+		return
+	}
+	csvExport := c.Query("format") == "csv"
+	if csvExport {
+		f.Offset, f.Limit = 0, maxAuditExport
+	}
+	entries, total, err := models.ListAuditLogs(f)
+	if err != nil {
+		logrus.Errorf("k8s: audit query failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	if !csvExport {
+		c.JSON(http.StatusOK, gin.H{"entries": entries, "total": total})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="k8s-audit-`+time.Now().Format("20060102-150405")+`.csv"`)
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"time", "user", "cluster", "namespace", "kind", "name", "action", "result", "status",
+		"client_ip", "before_hash", "after_hash", "detail"})
+	for _, e := range entries {
+		_ = w.Write([]string{
+			e.CreatedAt.Format(time.RFC3339), e.User, e.Cluster, e.Namespace, e.Kind, e.Name, e.Action,
+			e.Result, strconv.Itoa(e.Status), e.ClientIP, e.BeforeHash, e.AfterHash, e.Detail,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logrus.Warnf("k8s: audit export failed: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"gin-demo/models"
+)
+
+// hpaMetric is one metric of an HPA with its target and current value.
+type hpaMetric struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Current string `json:"current,omitempty"`
+}
+
+// hpaView summarises a horizontal pod autoscaler.
+type hpaView struct {
+	Name            string          `json:"name"`
+	Namespace       string          `json:"namespace"`
+	Target          string          `json:"target"`
+	MinReplicas     int32           `json:"minReplicas"`
+	MaxReplicas     int32           `json:"maxReplicas"`
+	CurrentReplicas int32           `json:"currentReplicas"`
+	DesiredReplicas int32           `json:"desiredReplicas"`
+	Metrics         []hpaMetric     `json:"metrics"`
+	Conditions      []nodeCondition `json:"conditions"`
+	LastScaleTime   *time.Time      `json:"lastScaleTime,omitempty"`
+	CreatedAt       time.Time       `json:"createdAt"`
+}
+
+// metricSpecName names a metric like kubectl get hpa, e.g. "cpu" or "app/requests".
+func metricSpecName(m autoscalingv2.MetricSpec) string {
+	switch m.Type {
+	case autoscalingv2.ResourceMetricSourceType:
+		if m.Resource != nil {
+			return string(m.Resource.Name)
+		}
+	case autoscalingv2.ContainerResourceMetricSourceType:
+		if m.ContainerResource != nil {
+			return m.ContainerResource.Container + "/" + string(m.ContainerResource.Name)
+		}
+	case autoscalingv2.PodsMetricSourceType:
+		if m.Pods != nil {
+			return m.Pods.Metric.Name
+		}
+	case autoscalingv2.ObjectMetricSourceType:
+		if m.Object != nil {
+			return m.Object.DescribedObject.Kind + "/" + m.Object.DescribedObject.Name + " " + m.Object.Metric.Name
+		}
+	case autoscalingv2.ExternalMetricSourceType:
+		if m.External != nil {
+			return m.External.Metric.Name
+		}
+	}
+	return ""
+}
+
+// metricStatusName names a current metric the same way as metricSpecName.
+func metricStatusName(m autoscalingv2.MetricStatus) string {
+	switch m.Type {
+	case autoscalingv2.ResourceMetricSourceType:
+		if m.Resource != nil {
+			return string(m.Resource.Name)
+		}
+	case autoscalingv2.ContainerResourceMetricSourceType:
+		if m.ContainerResource != nil {
+			return m.ContainerResource.Container + "/" + string(m.ContainerResource.Name)
+		}
+	case autoscalingv2.PodsMetricSourceType:
+		if m.Pods != nil {
+			return m.Pods.Metric.Name
+		}
+	case autoscalingv2.ObjectMetricSourceType:
+		if m.Object != nil {
+			return m.Object.DescribedObject.Kind + "/" + m.Object.DescribedObject.Name + " " + m.Object.Metric.Name
+		}
+	case autoscalingv2.ExternalMetricSourceType:
+		if m.External != nil {
+			return m.External.Metric.Name
+		}
+	}
+	return ""
+}
+
+func metricSpecTarget(m autoscalingv2.MetricSpec) autoscalingv2.MetricTarget {
+	switch {
+	case m.Resource != nil:
+		return m.Resource.Target
+	case m.ContainerResource != nil:
+		return m.ContainerResource.Target
+	case m.Pods != nil:
+		return m.Pods.Target
+	case m.Object != nil:
+		return m.Object.Target
+	case m.External != nil:
+		return m.External.Target
+	}
+	return autoscalingv2.MetricTarget{}
+}
+
+func metricStatusCurrent(m autoscalingv2.MetricStatus) autoscalingv2.MetricValueStatus {
+	switch {
+	case m.Resource != nil:
+		return m.Resource.Current
+	case m.ContainerResource != nil:
+		return m.ContainerResource.Current
+	case m.Pods != nil:
+		return m.Pods.Current
+	case m.Object != nil:
+		return m.Object.Current
+	case m.External != nil:
+		return m.External.Current
+	}
+	return autoscalingv2.MetricValueStatus{}
+}
+
+func formatMetricTarget(t autoscalingv2.MetricTarget) string {
+	switch {
+	case t.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *t.AverageUtilization)
+	case t.AverageValue != nil:
+		return t.AverageValue.String() + " (average)"
+	case t.Value != nil:
+		return t.Value.String()
+	}
+	return ""
+}
+
+func formatMetricCurrent(v autoscalingv2.MetricValueStatus) string {
+	switch {
+	case v.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *v.AverageUtilization)
+	case v.AverageValue != nil:
+		return v.AverageValue.String() + " (average)"
+	case v.Value != nil:
+		return v.Value.String()
+	}
+	return ""
+}
+
+func newHPAView(h *autoscalingv2.HorizontalPodAutoscaler) hpaView {
+	v := hpaView{
+		Name:            h.Name,
+		Namespace:       h.Namespace,
+		Target:          h.Spec.ScaleTargetRef.Kind + "/" + h.Spec.ScaleTargetRef.Name,
+		MinReplicas:     1,
+		MaxReplicas:     h.Spec.MaxReplicas,
+		CurrentReplicas: h.Status.CurrentReplicas,
+		DesiredReplicas: h.Status.DesiredReplicas,
+		Metrics:         []hpaMetric{},
+		Conditions:      []nodeCondition{},
+		CreatedAt:       h.CreationTimestamp.Time,
+	}
+	if h.Spec.MinReplicas != nil {
+		v.MinReplicas = *h.Spec.MinReplicas
+	}
+	if h.Status.LastScaleTime != nil {
+		t := h.Status.LastScaleTime.Time
+		v.LastScaleTime = &t
+	}
+	current := map[string]string{}
+	for _, m := range h.Status.CurrentMetrics {
+		current[string(m.Type)+"/"+metricStatusName(m)] = formatMetricCurrent(metricStatusCurrent(m))
+	}
+	for _, m := range h.Spec.Metrics {
+		name := metricSpecName(m)
+		v.Metrics = append(v.Metrics, hpaMetric{
+			Type:    string(m.Type),
+			Name:    name,
+			Target:  formatMetricTarget(metricSpecTarget(m)),
+			Current: current[string(m.Type)+"/"+name],
+		})
+	}
+	for _, cond := range h.Status.Conditions {
+		v.Conditions = append(v.Conditions, nodeCondition{
+			Type:    string(cond.Type),
+			Status:  string(cond.Status),
+			Reason:  cond.Reason,
+			Message: cond.Message,
+		})
+	}
+	return v
+}
+
+// GetHorizontalPodAutoscalers returns the HPAs of a namespace with their current and
+// target metrics
+func GetHorizontalPodAutoscalers(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	hpas, err := cs.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	views := make([]hpaView, 0, len(hpas.Items))
+	for i := range hpas.Items {
+		views = append(views, newHPAView(&hpas.Items[i]))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	c.JSON(http.StatusOK, gin.H{"horizontalpodautoscalers": views, "source": sourceLive})
+}
+
+// GetHorizontalPodAutoscalerYAML returns YAML of an HPA
+func GetHorizontalPodAutoscalerYAML(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbGet)
+	if !ok {
+		return
+	}
+	hpa, err := cs.AutoscalingV2().HorizontalPodAutoscalers(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	obj, err := toObjectMap(hpa, "horizontalpodautoscalers")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeObject(c, obj)
+}
+
+// UpdateHorizontalPodAutoscaler previews or applies YAML changes to an HPA, see applyYAML
+func UpdateHorizontalPodAutoscaler(c *gin.Context) {
+	applyYAML(c, "horizontalpodautoscalers")
+}
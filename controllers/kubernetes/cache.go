@@ -0,0 +1,143 @@
+package kubernetes
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// where list responses were served from
+const (
+	sourceCache = "cache"
+	sourceLive  = "live"
+)
+
+// cache states reported by ListClusters
+const (
+	cacheOff     = "off"
+	cacheSyncing = "syncing"
+	cacheSynced  = "synced"
+)
+
+var cacheResync = 10 * time.Minute
+
+// resourceCache serves list endpoints of one cluster from shared informers.
+type resourceCache struct {
+	factory informers.SharedInformerFactory
+	stop    chan struct{}
+	syncs   []cache.InformerSynced
+
+	deployments  appslisters.DeploymentLister
+	daemonsets   appslisters.DaemonSetLister
+	statefulsets appslisters.StatefulSetLister
+	jobs         batchlisters.JobLister
+	cronjobs     batchlisters.CronJobLister
+	services     corelisters.ServiceLister
+	pods         corelisters.PodLister
+}
+
+func newResourceCache(cs kubernetes.Interface) *resourceCache {
+	f := informers.NewSharedInformerFactory(cs, cacheResync)
+	rc := &resourceCache{factory: f, stop: make(chan struct{})}
+
+	// listers must be requested before Start so their informers get started
+	deps := f.Apps().V1().Deployments()
+	dss := f.Apps().V1().DaemonSets()
+	stss := f.Apps().V1().StatefulSets()
+	jobs := f.Batch().V1().Jobs()
+	cjs := f.Batch().V1().CronJobs()
+	svcs := f.Core().V1().Services()
+	pods := f.Core().V1().Pods()
+	rc.deployments = deps.Lister()
+	rc.daemonsets = dss.Lister()
+	rc.statefulsets = stss.Lister()
+	rc.jobs = jobs.Lister()
+	rc.cronjobs = cjs.Lister()
+	rc.services = svcs.Lister()
+	rc.pods = pods.Lister()
+	rc.syncs = []cache.InformerSynced{
+		deps.Informer().HasSynced, dss.Informer().HasSynced, stss.Informer().HasSynced,
+		jobs.Informer().HasSynced, cjs.Informer().HasSynced, svcs.Informer().HasSynced,
+		pods.Informer().HasSynced,
+	}
+
+	f.Start(rc.stop)
+	return rc
+}
+
+// synced reports whether every informer finished its initial list.
+func (rc *resourceCache) synced() bool {
+	for _, s := range rc.syncs {
+		if !s() {
+			return false
+		}
+	}
+	return true
+}
+
+func (rc *resourceCache) shutdown() {
+	close(rc.stop)
+	rc.factory.Shutdown()
+}
+
+// resourceCache starts the informers of the cluster on first use.
+func (cl *cluster) resourceCache() *resourceCache {
+	cl.cacheMu.Lock()
+	defer cl.cacheMu.Unlock()
+	if cl.cache == nil {
+		logrus.Infof("k8s: starting informer cache for cluster %s", cl.name)
+		cl.cache = newResourceCache(cl.client)
+	}
+	return cl.cache
+}
+
+// cacheState reports the informer cache state without starting it.
+func (cl *cluster) cacheState() string {
+	cl.cacheMu.Lock()
+	defer cl.cacheMu.Unlock()
+	if cl.cache == nil {
+		return cacheOff
+	}
+	if !cl.cache.synced() {
+		return cacheSyncing
+	}
+	return cacheSynced
+}
+
+// stopCache stops the informers of a cluster that is being removed or replaced.
+func (cl *cluster) stopCache() {
+	cl.cacheMu.Lock()
+	defer cl.cacheMu.Unlock()
+	if cl.cache != nil {
+		cl.cache.shutdown()
+		cl.cache = nil
+	}
+}
+
+// cacheFor returns the synced informer cache of cl, or nil when the request asks
+// for a live read (?fresh=true) or the cache is still syncing.
+func cacheFor(c *gin.Context, cl *cluster) *resourceCache {
+	if c.Query("fresh") == "true" {
+		return nil
+	}
+	rc := cl.resourceCache()
+	if !rc.synced() {
+		return nil
+	}
+	return rc
+}
+
+// sortByName orders lister results, which come back in random order.
+func sortByName[T metav1.Object](items []T) []T {
+	sort.Slice(items, func(i, j int) bool { return items[i].GetName() < items[j].GetName() })
+	return items
+}
@@ -0,0 +1,504 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"gin-demo/mailer"
+	"gin-demo/models"
+)
+
+// changesPage is where approvers review change requests; it is named in the emails.
+const changesPage = "/static/changes.html"
+
+// mailSend delivers change notifications; tests replace it.
+var mailSend = mailer.Send
+
+// sendMails sends one email per address without blocking the request, like the
+// verification codes.
+func sendMails(to []string, subject, body string) {
+	send := mailSend
+	go func() {
+		for _, addr := range to {
+			if err := send(addr, subject, body); err != nil {
+				logrus.Errorf("k8s: change mail to %s failed: %v", addr, err)
+			}
+		}
+	}()
+}
+
+func changeTarget(cr *models.ChangeRequest) string {
+	return fmt.Sprintf("%s %s/%s (cluster %s)", cr.Kind, cr.Namespace, cr.Name, cr.Cluster)
+}
+
+// notifyApprovers mails the users who may approve cr, except its requester.
+func notifyApprovers(cr models.ChangeRequest) {
+	users, err := models.UsersWithPermission(models.PermK8sApprove)
+	if err != nil {
+		logrus.Errorf("k8s: approver lookup failed for change %d: %v", cr.ID, err)
+		return
+	}
+	var to []string
+	for _, u := range users {
+		if u.Username == cr.Requester || u.Email == "" {
+			continue
+		}
+		if ok, err := models.NamespaceAllowed(u.Username, cr.Cluster, cr.Namespace, models.VerbUpdate); err != nil || !ok {
+			continue
+		}
+		to = append(to, u.Email)
+	}
+	if len(to) == 0 {
+		logrus.Warnf("k8s: change %d on %s has no approver to notify", cr.ID, changeTarget(&cr))
+		return
+	}
+	subject := fmt.Sprintf("变更审批 #%d: %s", cr.ID, changeTarget(&cr))
+	body := fmt.Sprintf("%s 申请修改受保护命名空间中的 %s。\n\n变更内容:\n%s\n\n请在 %s 审批。",
+		cr.Requester, changeTarget(&cr), cr.Diff, changesPage)
+	sendMails(to, subject, body)
+}
+
+// notifyRequester mails the requester of cr about its outcome.
+func notifyRequester(cr models.ChangeRequest) {
+	email, err := models.UserEmail(cr.Requester)
+	if err != nil || email == "" {
+		logrus.Warnf("k8s: no email for requester %s of change %d: %v", cr.Requester, cr.ID, err)
+		return
+	}
+	subject := fmt.Sprintf("变更 #%d %s: %s", cr.ID, cr.Status, changeTarget(&cr))
+	body := fmt.Sprintf("你对 %s 的变更申请已由 %s 处理，状态: %s。", changeTarget(&cr), cr.Approver, cr.Status)
+	if cr.Comment != "" {
+		body += "\n备注: " + cr.Comment
+	}
+	if cr.Result != "" {
+		body += "\n结果: " + cr.Result
+	}
+	sendMails([]string{email}, subject, body)
+}
+
+// requestChange stores cr as a pending change request instead of applying it and
+// notifies the approvers.
+func requestChange(c *gin.Context, cr *models.ChangeRequest, diff []fieldChange) {
+	cr.Requester = currentUser(c)
+	if data, err := json.Marshal(diff); err == nil {
+		cr.Diff = string(data)
+	}
+	if err := models.CreateChangeRequest(cr); err != nil {
+		logrus.Errorf("k8s: change request create failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	rec := auditFor(c)
+	rec.kind = cr.Kind
+	rec.action = "request"
+	rec.detail = "change=" + strconv.FormatUint(uint64(cr.ID), 10)
+	logrus.Infof("k8s: %s requested change %d on %s", cr.Requester, cr.ID, changeTarget(cr))
+	notifyApprovers(*cr)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "namespace is protected; the change waits for approval",
+		"changeRequest": cr,
+		"diff":          diff,
+	})
+}
+
+// protectedNamespace reports whether changes to namespace in the cluster of the
+// request need approval. It answers the request when the lookup fails.
+func protectedNamespace(c *gin.Context, namespace string) (*cluster, bool, bool) {
+	cl, ok := clusterFor(c)
+	if !ok {
+		return nil, false, false
+	}
+	protected, err := models.NamespaceProtected(cl.name, namespace)
+	if err != nil {
+		logrus.Errorf("k8s: protected namespace lookup failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return nil, false, false
+	}
+	return cl, protected, true
+}
+
+// requireUnprotected answers 409 when namespace is protected, for changes that cannot
+// be filed as a change request such as deletes; it reports whether to go ahead.
+func requireUnprotected(c *gin.Context, namespace string) bool {
+	_, protected, ok := protectedNamespace(c, namespace)
+	if !ok {
+		return false
+	}
+	if protected {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "namespace " + namespace + " is protected; its changes need approval",
+			"hint":  "edits go through POST /api/k8s/<kind>/update?confirm=true, which files a change request; ask an administrator to unprotect the namespace for anything else",
+		})
+		return false
+	}
+	return true
+}
+
+// requestPatchChange files a patch of live, an object of kind, as a change request.
+// Approval sends the same patch, so only the fields it names are taken over rather
+// than the whole object.
+func requestPatchChange(c *gin.Context, cl *cluster, kind string, live runtime.Object, pt types.PatchType, patch []byte) {
+	rk := typedKinds[kind]
+	proposed, err := patchedObject(rk, live, pt, patch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	diff, err := diffObjects(live, proposed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	doc, err := sigsyaml.JSONToYAML(patch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	m, err := meta.Accessor(live)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	apiVersion, k := rk.gvk.ToAPIVersionAndKind()
+	requestChange(c, &models.ChangeRequest{
+		Cluster:         cl.name,
+		Namespace:       m.GetNamespace(),
+		Resource:        kind,
+		APIVersion:      apiVersion,
+		Kind:            k,
+		Name:            m.GetName(),
+		YAML:            string(doc),
+		PatchType:       string(pt),
+		ResourceVersion: m.GetResourceVersion(),
+	}, diff)
+}
+
+// patchedObject returns live with a strategic merge or JSON patch applied locally.
+func patchedObject(rk resourceKind, live runtime.Object, pt types.PatchType, patch []byte) (runtime.Object, error) {
+	original, err := json.Marshal(live)
+	if err != nil {
+		return nil, err
+	}
+	var merged []byte
+	switch pt {
+	case types.StrategicMergePatchType:
+		merged, err = strategicpatch.StrategicMergePatch(original, patch, rk.newObject())
+	case types.JSONPatchType:
+		var ops jsonpatch.Patch
+		if ops, err = jsonpatch.DecodePatch(patch); err == nil {
+			merged, err = ops.Apply(original)
+		}
+	default:
+		err = fmt.Errorf("unsupported patch type %s", pt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	proposed := rk.newObject()
+	if err := json.Unmarshal(merged, proposed); err != nil {
+		return nil, err
+	}
+	return proposed, nil
+}
+
+// changePatch returns the patch sending cr to the API server: the stored patch, or
+// a server-side apply of the proposed object.
+func changePatch(cr *models.ChangeRequest, gvk schema.GroupVersionKind) (types.PatchType, []byte, metav1.PatchOptions, error) {
+	opts := metav1.PatchOptions{FieldManager: fieldManager}
+	if cr.PatchType != "" {
+		body, err := sigsyaml.YAMLToJSON([]byte(cr.YAML))
+		return types.PatchType(cr.PatchType), body, opts, err
+	}
+	body, _, err := decodeApplyBody(cr.YAML, gvk, cr.Namespace, cr.Name)
+	force := cr.Force
+	opts.Force = &force
+	return types.ApplyPatchType, body, opts, err
+}
+
+// changeClient returns the client and kind of the object changed by cr.
+func changeClient(cl *cluster, cr *models.ChangeRequest) (kindClient, schema.GroupVersionKind, error) {
+	if rk, ok := typedKinds[cr.Resource]; ok {
+		return rk.client(cl.client, cr.Namespace), rk.gvk, nil
+	}
+	parts := strings.Split(cr.Resource, "/")
+	if len(parts) != 3 {
+		return nil, schema.GroupVersionKind{}, fmt.Errorf("unknown resource %q", cr.Resource)
+	}
+	gvr, res, err := cl.resolveResource(parts[0], parts[1], parts[2])
+	if err != nil {
+		return nil, schema.GroupVersionKind{}, err
+	}
+	return dynamicKind{cl.dynamic.Resource(gvr).Namespace(cr.Namespace)}, gvr.GroupVersion().WithKind(res.Kind), nil
+}
+
+// changeView hides the proposed YAML of secrets from users without k8s:secrets.
+func changeView(cr models.ChangeRequest, revealSecrets bool) models.ChangeRequest {
+	if cr.Resource == "secrets" && !revealSecrets {
+		cr.YAML = ""
+	}
+	return cr
+}
+
+// changeRequest loads the change request named by the :id parameter.
+func changeRequest(c *gin.Context) (*models.ChangeRequest, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	cr, err := models.GetChangeRequest(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "change request not found"})
+			return nil, false
+		}
+		logrus.Errorf("k8s: change request lookup failed id=%v: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return nil, false
+	}
+	return cr, true
+}
+
+// ListChanges returns the change requests of the namespaces the user may see, newest
+// first; status filters them, e.g. status=pending.
+func ListChanges(c *gin.Context) {
+	crs, err := models.ListChangeRequests(c.Query("status"))
+	if err != nil {
+		logrus.Errorf("k8s: change request list failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	username := currentUser(c)
+	reveal, err := models.UserHasPermission(username, models.PermK8sSecrets)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	visible := make([]models.ChangeRequest, 0, len(crs))
+	for _, cr := range crs {
+		if ok, err := models.NamespaceAllowed(username, cr.Cluster, cr.Namespace, models.VerbGet); err != nil || !ok {
+			continue
+		}
+		visible = append(visible, changeView(cr, reveal))
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": visible})
+}
+
+// ApproveChange applies a pending change request. The approver must not be the
+// requester, and the change fails with 409 when the object was modified after the
+// change was requested.
+func ApproveChange(c *gin.Context) {
+	cr, ok := changeRequest(c)
+	if !ok {
+		return
+	}
+	rec := auditFor(c)
+	rec.cluster, rec.namespace, rec.kind, rec.name = cr.Cluster, cr.Namespace, cr.Kind, cr.Name
+	rec.detail = "change=" + c.Param("id")
+	if !authorizeClusterNamespace(c, cr.Cluster, cr.Namespace, models.VerbUpdate) {
+		return
+	}
+	approver := currentUser(c)
+	if approver == cr.Requester {
+		c.JSON(http.StatusForbidden, gin.H{"error": "a change must be approved by someone other than its requester"})
+		return
+	}
+	if cr.Status != models.ChangePending {
+		c.JSON(http.StatusConflict, gin.H{"error": "change request is " + cr.Status})
+		return
+	}
+	cl, found := getCluster(cr.Cluster)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cluster not found: " + cr.Cluster})
+		return
+	}
+	kc, gvk, err := changeClient(cl, cr)
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := models.DecideChangeRequest(cr, models.ChangeApproved, approver, c.PostForm("comment")); err != nil {
+		if errors.Is(err, models.ErrChangeNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("k8s: change request %d approve failed: %v", cr.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+
+	// from here on the request is claimed; record how it ends and tell the requester
+	status, result := models.ChangeFailed, ""
+	defer func() {
+		if err := models.FinishChangeRequest(cr, status, result); err != nil {
+			logrus.Errorf("k8s: change request %d finish failed: %v", cr.ID, err)
+		}
+		logrus.Infof("k8s: %s approved change %d on %s: %s", approver, cr.ID, changeTarget(cr), status)
+		notifyRequester(*cr)
+	}()
+
+	ctx := context.TODO()
+	live, err := kc.get(ctx, cr.Name)
+	if err != nil {
+		result = err.Error()
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	liveMeta, err := meta.Accessor(live)
+	if err != nil {
+		result = err.Error()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rec.before = objectHash(live)
+	if liveMeta.GetResourceVersion() != cr.ResourceVersion {
+		status = models.ChangeConflict
+		result = fmt.Sprintf("resourceVersion drifted from %s to %s", cr.ResourceVersion, liveMeta.GetResourceVersion())
+		c.JSON(http.StatusConflict, gin.H{
+			"error":                  "resourceVersion conflict: the object changed after the change was requested",
+			"requestResourceVersion": cr.ResourceVersion,
+			"liveResourceVersion":    liveMeta.GetResourceVersion(),
+			"hint":                   "the requester must submit the change again",
+		})
+		return
+	}
+	pt, body, opts, err := changePatch(cr, gvk)
+	if err != nil {
+		result = err.Error()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	applied, err := kc.patch(ctx, cr.Name, pt, body, opts)
+	if err != nil {
+		result = err.Error()
+		writeApplyError(c, err)
+		return
+	}
+	status, result = models.ChangeApplied, "ok"
+	rec.after = objectHash(applied)
+	appliedMeta, _ := meta.Accessor(applied)
+	c.JSON(http.StatusOK, gin.H{"message": "applied", "changeRequest": changeView(*cr, false), "resourceVersion": appliedMeta.GetResourceVersion()})
+}
+
+// RejectChange rejects a pending change request.
+func RejectChange(c *gin.Context) {
+	decideChange(c, models.ChangeRejected)
+}
+
+// WithdrawChange lets the requester of a pending change request take it back.
+func WithdrawChange(c *gin.Context) {
+	decideChange(c, models.ChangeWithdrawn)
+}
+
+// decideChange closes a pending change request without applying it: rejected by an
+// approver or withdrawn by its requester. The requester is mailed about rejections.
+func decideChange(c *gin.Context, status string) {
+	cr, ok := changeRequest(c)
+	if !ok {
+		return
+	}
+	rec := auditFor(c)
+	rec.cluster, rec.namespace, rec.kind, rec.name = cr.Cluster, cr.Namespace, cr.Kind, cr.Name
+	rec.detail = "change=" + c.Param("id")
+	if !authorizeClusterNamespace(c, cr.Cluster, cr.Namespace, models.VerbUpdate) {
+		return
+	}
+	user := currentUser(c)
+	if status == models.ChangeWithdrawn && user != cr.Requester {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the requester may withdraw a change"})
+		return
+	}
+	if err := models.DecideChangeRequest(cr, status, user, c.PostForm("comment")); err != nil {
+		if errors.Is(err, models.ErrChangeNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("k8s: change request %d %s failed: %v", cr.ID, status, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	logrus.Infof("k8s: %s %s change %d on %s", user, status, cr.ID, changeTarget(cr))
+	if user != cr.Requester {
+		notifyRequester(*cr)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": status, "changeRequest": changeView(*cr, false)})
+}
+
+// ListProtectedNamespaces returns the namespaces whose updates need approval
+func ListProtectedNamespaces(c *gin.Context) {
+	ps, err := models.ListProtectedNamespaces()
+	if err != nil {
+		logrus.Errorf("k8s: protected namespace list failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"protected": ps})
+}
+
+// ProtectNamespace marks a namespace as protected; cluster "*" or empty protects it
+// in every cluster
+func ProtectNamespace(c *gin.Context) {
+	type req struct {
+		Cluster   string `json:"cluster"`
+		Namespace string `json:"namespace" binding:"required"`
+	}
+	var r req
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if r.Cluster == "" {
+		r.Cluster = models.AllNamespaces
+	}
+	rec := auditFor(c)
+	rec.cluster, rec.namespace = r.Cluster, r.Namespace
+	p, err := models.CreateProtectedNamespace(r.Cluster, r.Namespace)
+	if err != nil {
+		if errors.Is(err, models.ErrProtectedNamespaceExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("k8s: protect namespace failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	logrus.Infof("k8s: %s protected namespace %s in cluster %s", currentUser(c), p.Namespace, p.Cluster)
+	c.JSON(http.StatusCreated, gin.H{"protected": p})
+}
+
+// UnprotectNamespace removes a namespace protection by ID
+func UnprotectNamespace(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	auditFor(c).detail = "id=" + c.Param("id")
+	if err := models.DeleteProtectedNamespace(uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		logrus.Errorf("k8s: unprotect namespace failed id=%v: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
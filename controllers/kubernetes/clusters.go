@@ -0,0 +1,388 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"gin-demo/models"
+)
+
+// cluster sources
+const (
+	clusterSourceFile = "file"
+	clusterSourceDB   = "db"
+	// clusters handed to RegisterRoutes through WithClients
+	clusterSourceInjected = "injected"
+)
+
+// cluster is one registered kubernetes cluster.
+type cluster struct {
+	name   string
+	source string
+	config *rest.Config
+	client kubernetes.Interface
+	// dynamic client and cached discovery for the generic resource browser
+	dynamic   dynamic.Interface
+	discovery discovery.CachedDiscoveryInterface
+
+	// informer cache, started on first list request
+	cacheMu sync.Mutex
+	cache   *resourceCache
+}
+
+var (
+	clustersMu     sync.RWMutex
+	clusters       = map[string]*cluster{}
+	defaultCluster string
+)
+
+// checkKubeconfig rejects kubeconfigs submitted through the API that would make the
+// server run commands (exec and auth-provider plugins) or read its own files
+// (certificate, key and token paths). Credentials must be inlined.
+func checkKubeconfig(raw *clientcmdapi.Config) error {
+	for name, auth := range raw.AuthInfos {
+		switch {
+		case auth.Exec != nil:
+			return fmt.Errorf("user %s: exec credential plugins are not allowed", name)
+		case auth.AuthProvider != nil:
+			return fmt.Errorf("user %s: auth-provider plugins are not allowed", name)
+		case auth.TokenFile != "", auth.ClientCertificate != "", auth.ClientKey != "":
+			return fmt.Errorf("user %s: file paths are not allowed, inline tokens and client-certificate-data/client-key-data instead", name)
+		}
+	}
+	for name, cluster := range raw.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return fmt.Errorf("cluster %s: file paths are not allowed, inline certificate-authority-data instead", name)
+		}
+	}
+	return nil
+}
+
+func newCluster(name, source string, raw clientcmdapi.Config, context string) (*cluster, error) {
+	config, err := clientcmd.NewNonInteractiveClientConfig(raw, context, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &cluster{
+		name:      name,
+		source:    source,
+		config:    config,
+		client:    cs,
+		dynamic:   dyn,
+		discovery: memory.NewMemCacheClient(cs.Discovery()),
+	}, nil
+}
+
+// newClusterFromClients wraps clients built elsewhere, such as the client-go fakes.
+// Such clusters have no rest config, so exec is not available on them.
+func newClusterFromClients(name string, cs kubernetes.Interface, dyn dynamic.Interface) *cluster {
+	return &cluster{
+		name:      name,
+		source:    clusterSourceInjected,
+		client:    cs,
+		dynamic:   dyn,
+		discovery: memory.NewMemCacheClient(cs.Discovery()),
+	}
+}
+
+// registerCluster adds cl to the registry. A name that is already taken keeps its
+// first registration, so a kubeconfig context cannot shadow a cluster added through
+// the API; it reports whether cl was added.
+func registerCluster(cl *cluster, makeDefault bool) bool {
+	clustersMu.Lock()
+	defer clustersMu.Unlock()
+	if old, ok := clusters[cl.name]; ok {
+		if old != cl {
+			logrus.Warnf("k8s: cluster name %s from %s is already taken by a cluster from %s; keeping the first", cl.name, cl.source, old.source)
+		}
+		return false
+	}
+	clusters[cl.name] = cl
+	if makeDefault || defaultCluster == "" {
+		defaultCluster = cl.name
+	}
+	return true
+}
+
+// unregisterCluster removes the cluster called name from the registry; the default
+// falls back to the first remaining cluster by name, none when empty.
+func unregisterCluster(name string) (*cluster, bool) {
+	clustersMu.Lock()
+	defer clustersMu.Unlock()
+	cl, ok := clusters[name]
+	if !ok {
+		return nil, false
+	}
+	delete(clusters, name)
+	if defaultCluster == name {
+		defaultCluster = ""
+		for n := range clusters {
+			if defaultCluster == "" || n < defaultCluster {
+				defaultCluster = n
+			}
+		}
+	}
+	return cl, true
+}
+
+// loadFileClusters registers every context of conf/kubernetes.config (its current
+// context becomes the default cluster) and the current context of each
+// conf/clusters/<name>.config under <name>.
+func loadFileClusters() error {
+	kubeconfig := filepath.Join("conf", "kubernetes.config")
+	raw, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		return err
+	}
+	for ctxName := range raw.Contexts {
+		cl, err := newCluster(ctxName, clusterSourceFile, *raw, ctxName)
+		if err != nil {
+			return fmt.Errorf("%s context %s: %w", kubeconfig, ctxName, err)
+		}
+		registerCluster(cl, ctxName == raw.CurrentContext)
+	}
+
+	extra, _ := filepath.Glob(filepath.Join("conf", "clusters", "*.config"))
+	for _, path := range extra {
+		name := strings.TrimSuffix(filepath.Base(path), ".config")
+		raw, err := clientcmd.LoadFromFile(path)
+		if err != nil {
+			logrus.Errorf("k8s: load kubeconfig %s failed: %v", path, err)
+			continue
+		}
+		cl, err := newCluster(name, clusterSourceFile, *raw, "")
+		if err != nil {
+			logrus.Errorf("k8s: build client for %s failed: %v", path, err)
+			continue
+		}
+		registerCluster(cl, false)
+	}
+	return nil
+}
+
+// LoadStoredClusters registers the clusters added through the admin API.
+// It must be called after models.InitDB.
+func LoadStoredClusters() error {
+	cls, err := models.ListClusters()
+	if err != nil {
+		return err
+	}
+	for _, m := range cls {
+		raw, err := clientcmd.Load([]byte(m.Kubeconfig))
+		if err == nil {
+			err = checkKubeconfig(raw)
+		}
+		if err != nil {
+			logrus.Errorf("k8s: stored cluster %s has invalid kubeconfig: %v", m.Name, err)
+			continue
+		}
+		cl, err := newCluster(m.Name, clusterSourceDB, *raw, m.Context)
+		if err != nil {
+			logrus.Errorf("k8s: build client for stored cluster %s failed: %v", m.Name, err)
+			continue
+		}
+		registerCluster(cl, false)
+	}
+	return nil
+}
+
+func getCluster(name string) (*cluster, bool) {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+	if name == "" {
+		name = defaultCluster
+	}
+	cl, ok := clusters[name]
+	return cl, ok
+}
+
+// clusterFor returns the cluster selected by the "cluster" query parameter, or the
+// default cluster when it is omitted. It aborts with 404 for unknown clusters.
+func clusterFor(c *gin.Context) (*cluster, bool) {
+	name := c.Query("cluster")
+	cl, ok := getCluster(name)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown cluster", "cluster": name})
+		return nil, false
+	}
+	return cl, true
+}
+
+// clientFor returns the clientset of the cluster selected by the request.
+func clientFor(c *gin.Context) (kubernetes.Interface, bool) {
+	cl, ok := clusterFor(c)
+	if !ok {
+		return nil, false
+	}
+	return cl.client, true
+}
+
+// server returns the apiserver URL, empty for injected clients.
+func (cl *cluster) server() string {
+	if cl.config == nil {
+		return ""
+	}
+	return cl.config.Host
+}
+
+// probe checks whether the cluster answers /version within a short timeout.
+func (cl *cluster) probe() (string, error) {
+	if cl.config == nil {
+		v, err := cl.client.Discovery().ServerVersion()
+		if err != nil {
+			return "", err
+		}
+		return v.GitVersion, nil
+	}
+	cfg := rest.CopyConfig(cl.config)
+	cfg.Timeout = 3 * time.Second
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+	v, err := dc.ServerVersion()
+	if err != nil {
+		return "", err
+	}
+	return v.GitVersion, nil
+}
+
+// ListClusters handles GET /api/k8s/clusters
+func ListClusters(c *gin.Context) {
+	clustersMu.RLock()
+	list := make([]*cluster, 0, len(clusters))
+	for _, cl := range clusters {
+		list = append(list, cl)
+	}
+	def := defaultCluster
+	clustersMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	type item struct {
+		Name      string `json:"name"`
+		Source    string `json:"source"`
+		Server    string `json:"server"`
+		Default   bool   `json:"default"`
+		Reachable bool   `json:"reachable"`
+		Cache     string `json:"cache"`
+		Version   string `json:"version,omitempty"`
+		Error     string `json:"error,omitempty"`
+	}
+	res := make([]item, len(list))
+	var wg sync.WaitGroup
+	for i, cl := range list {
+		res[i] = item{Name: cl.name, Source: cl.source, Server: cl.server(), Default: cl.name == def, Cache: cl.cacheState()}
+		wg.Add(1)
+		go func(it *item, cl *cluster) {
+			defer wg.Done()
+			v, err := cl.probe()
+			if err != nil {
+				it.Error = err.Error()
+				return
+			}
+			it.Reachable = true
+			it.Version = v
+		}(&res[i], cl)
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, gin.H{"clusters": res})
+}
+
+// AddCluster handles POST /api/k8s/clusters
+func AddCluster(c *gin.Context) {
+	type req struct {
+		Name       string `json:"name" binding:"required"`
+		Context    string `json:"context"`
+		Kubeconfig string `json:"kubeconfig" binding:"required"`
+	}
+	var r req
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rec := auditFor(c)
+	rec.cluster, rec.name, rec.detail = r.Name, r.Name, "context="+r.Context
+	if _, exists := getCluster(r.Name); exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "cluster already exists"})
+		return
+	}
+	raw, err := clientcmd.Load([]byte(r.Kubeconfig))
+	if err == nil {
+		err = checkKubeconfig(raw)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid kubeconfig: " + err.Error()})
+		return
+	}
+	cl, err := newCluster(r.Name, clusterSourceDB, *raw, r.Context)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := models.CreateCluster(r.Name, r.Context, r.Kubeconfig); err != nil {
+		if errors.Is(err, models.ErrClusterExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !registerCluster(cl, false) {
+		// a kubeconfig file registered the name meanwhile
+		if err := models.DeleteCluster(r.Name); err != nil {
+			logrus.Errorf("k8s: cleanup of stored cluster %s failed: %v", r.Name, err)
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "cluster already exists"})
+		return
+	}
+	logrus.Infof("k8s: %s added cluster %s (%s)", currentUser(c), cl.name, cl.config.Host)
+	c.JSON(http.StatusCreated, gin.H{"name": cl.name, "server": cl.config.Host})
+}
+
+// RemoveCluster handles DELETE /api/k8s/clusters/:name
+func RemoveCluster(c *gin.Context) {
+	name := c.Param("name")
+	auditFor(c).cluster = name
+	cl, ok := getCluster(name)
+	if !ok || name == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown cluster"})
+		return
+	}
+	if cl.source != clusterSourceDB {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cluster is configured by a kubeconfig file and cannot be removed"})
+		return
+	}
+	if err := models.DeleteCluster(name); err != nil && !errors.Is(err, models.ErrClusterNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if removed, ok := unregisterCluster(name); ok {
+		removed.stopCache()
+	}
+	logrus.Infof("k8s: %s removed cluster %s", currentUser(c), name)
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
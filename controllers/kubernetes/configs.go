@@ -0,0 +1,327 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"gin-demo/models"
+)
+
+// maskedValue replaces secret values in responses. Submitting it back in an edit
+// keeps the stored value.
+const maskedValue = "<masked>"
+
+// lastAppliedAnnotation is set by kubectl apply and holds the full object, values included.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// maskSecret returns the map form of s with every value of data and the
+// last-applied annotation replaced by maskedValue.
+func maskSecret(s *corev1.Secret) (map[string]interface{}, error) {
+	m, err := toObjectMap(s, "secrets")
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := m["data"].(map[string]interface{}); ok {
+		for k := range data {
+			data[k] = maskedValue
+		}
+	}
+	if md, ok := m["metadata"].(map[string]interface{}); ok {
+		if ann, ok := md["annotations"].(map[string]interface{}); ok {
+			if _, ok := ann[lastAppliedAnnotation]; ok {
+				ann[lastAppliedAnnotation] = maskedValue
+			}
+		}
+	}
+	return m, nil
+}
+
+// redactSecretDiff hides values of data, stringData and the last-applied annotation.
+func redactSecretDiff(changes []fieldChange) {
+	for i := range changes {
+		p := changes[i].Path
+		if p == "data" || p == "stringData" || strings.HasPrefix(p, "data.") || strings.HasPrefix(p, "stringData.") ||
+			p == "metadata.annotations."+lastAppliedAnnotation {
+			if changes[i].Old != nil {
+				changes[i].Old = maskedValue
+			}
+			if changes[i].New != nil {
+				changes[i].New = maskedValue
+			}
+		}
+	}
+}
+
+// unmaskSecret puts the live values back where an edited secret still holds maskedValue.
+func unmaskSecret(obj map[string]interface{}, live *corev1.Secret) error {
+	if data, ok := obj["data"].(map[string]interface{}); ok {
+		for k, v := range data {
+			if v != maskedValue {
+				continue
+			}
+			lv, ok := live.Data[k]
+			if !ok {
+				return fmt.Errorf("data.%s is masked but does not exist in the secret", k)
+			}
+			data[k] = base64.StdEncoding.EncodeToString(lv)
+		}
+	}
+	if md, ok := obj["metadata"].(map[string]interface{}); ok {
+		if ann, ok := md["annotations"].(map[string]interface{}); ok && ann[lastAppliedAnnotation] == maskedValue {
+			if lv, ok := live.Annotations[lastAppliedAnnotation]; ok {
+				ann[lastAppliedAnnotation] = lv
+			} else {
+				delete(ann, lastAppliedAnnotation)
+			}
+		}
+	}
+	return nil
+}
+
+// GetConfigMaps returns configmaps for a namespace
+func GetConfigMaps(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	configmaps, err := cs.CoreV1().ConfigMaps(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"configmaps": configmaps.Items, "source": sourceLive})
+}
+
+// GetConfigMapYAML returns YAML of a configmap
+func GetConfigMapYAML(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbGet)
+	if !ok {
+		return
+	}
+	cm, err := cs.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	obj, err := toObjectMap(cm, "configmaps")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeObject(c, obj)
+}
+
+// UpdateConfigMap previews or applies YAML changes to a configmap, see applyYAML
+func UpdateConfigMap(c *gin.Context) {
+	applyYAML(c, "configmaps")
+}
+
+// GetSecrets returns the secrets of a namespace with their values masked
+func GetSecrets(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	secrets, err := cs.CoreV1().Secrets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		m, err := maskSecret(&secrets.Items[i])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		items = append(items, m)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secrets": items, "source": sourceLive})
+}
+
+// GetSecretYAML returns YAML of a secret with masked values. reveal=true returns the
+// values; it needs the k8s:secrets permission and is written to the audit log.
+func GetSecretYAML(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbGet)
+	if !ok {
+		return
+	}
+	reveal := c.Query("reveal") == "true"
+	username := currentUser(c)
+	if reveal {
+		allowed, err := models.UserHasPermission(username, models.PermK8sSecrets)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden", "permission": models.PermK8sSecrets})
+			return
+		}
+	}
+
+	secret, err := cs.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !reveal {
+		obj, err := maskSecret(secret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		writeObject(c, obj)
+		return
+	}
+
+	cl, _ := clusterFor(c)
+	entry := &models.AuditLog{
+		User:      username,
+		Cluster:   cl.name,
+		Namespace: namespace,
+		Kind:      "Secret",
+		Name:      name,
+		Action:    "reveal",
+		Result:    "ok",
+		ClientIP:  c.ClientIP(),
+	}
+	if err := models.CreateAuditLog(entry); err != nil {
+		// never reveal without a record
+		logrus.Errorf("k8s: secret reveal audit failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "audit unavailable"})
+		return
+	}
+	logrus.Infof("k8s: secret revealed user=%s secret=%s/%s", username, namespace, name)
+	obj, err := toObjectMap(secret, "secrets")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeObject(c, obj)
+}
+
+// UpdateSecret previews or applies YAML changes to a secret like applyYAML. Values
+// left as maskedValue keep their stored content and the diff never shows values.
+func UpdateSecret(c *gin.Context) {
+	namespace := c.Query("ns")
+	name := c.Query("name")
+	yamlStr := c.PostForm("yaml")
+	if namespace == "" || name == "" || yamlStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace, name and yaml required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbUpdate) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	jsonData, err := sigsyaml.YAMLToJSON([]byte(yamlStr))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(jsonData, &obj); err != nil || obj == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "yaml must describe a single object"})
+		return
+	}
+	live, err := cs.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := unmaskSecret(obj, live); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rk := typedKinds["secrets"]
+	applyChange(c, "secrets", rk.client(cs, namespace), rk.gvk, namespace, name, string(body), redactSecretDiff)
+}
+
+// createFromYAML creates a single object of kind from the form field "yaml".
+// Protected namespaces are refused (409), like manifests.
+func createFromYAML(c *gin.Context, kind string) {
+	yamlStr := c.PostForm("yaml")
+	if yamlStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "yaml required"})
+		return
+	}
+	objs, err := decodeManifest(yamlStr, c.Query("ns"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rk := typedKinds[kind]
+	if len(objs) != 1 || objs[0].rk.gvk != rk.gvk {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "yaml must describe a single " + rk.gvk.Kind})
+		return
+	}
+	o := objs[0]
+	rec := auditFor(c)
+	rec.namespace, rec.name = o.namespace, o.name
+	if !authorizeNamespace(c, o.namespace, models.VerbCreate) {
+		return
+	}
+	if !requireUnprotected(c, o.namespace) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+	if _, err := rk.client(cs, o.namespace).create(context.TODO(), o.obj, metav1.CreateOptions{FieldManager: fieldManager}); err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "created", "namespace": o.namespace, "name": o.name})
+}
+
+// CreateConfigMap creates a configmap from YAML
+func CreateConfigMap(c *gin.Context) {
+	createFromYAML(c, "configmaps")
+}
+
+// CreateSecret creates a secret from YAML; data values are base64, stringData plain text
+func CreateSecret(c *gin.Context) {
+	createFromYAML(c, "secrets")
+}
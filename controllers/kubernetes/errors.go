@@ -0,0 +1,25 @@
+package kubernetes
+
+import (
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// apiErrorStatus maps a kubernetes API error to the HTTP status returned to the client.
+// Errors that do not come from the API server map to 500.
+func apiErrorStatus(err error) int {
+	switch {
+	case apierrors.IsNotFound(err):
+		return http.StatusNotFound
+	case apierrors.IsBadRequest(err), apierrors.IsInvalid(err):
+		return http.StatusBadRequest
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return http.StatusConflict
+	case apierrors.IsForbidden(err):
+		return http.StatusForbidden
+	case apierrors.IsGone(err), apierrors.IsResourceExpired(err):
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
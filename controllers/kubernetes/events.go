@@ -0,0 +1,200 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+
+	"gin-demo/models"
+)
+
+var (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+	// events attached to pod and workload responses
+	relatedEventLimit = 50
+)
+
+// eventView is the JSON form of a core/v1 event.
+type eventView struct {
+	Namespace string    `json:"namespace"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count"`
+	Source    string    `json:"source,omitempty"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// eventFilter selects events; empty fields match everything.
+type eventFilter struct {
+	namespace string
+	kind      string
+	name      string
+	eventType string
+	since     time.Time
+	limit     int
+	// match, when set, further filters on the involved object
+	match func(kind, name string) bool
+}
+
+// eventTime returns when an event was last seen, whichever field the reporter set.
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
+
+func newEventView(e *corev1.Event) eventView {
+	v := eventView{
+		Namespace: e.Namespace,
+		Kind:      e.InvolvedObject.Kind,
+		Name:      e.InvolvedObject.Name,
+		Type:      e.Type,
+		Reason:    e.Reason,
+		Message:   e.Message,
+		Count:     e.Count,
+		Source:    e.Source.Component,
+		FirstSeen: e.FirstTimestamp.Time,
+		LastSeen:  eventTime(e),
+	}
+	if v.Source == "" {
+		v.Source = e.ReportingController
+	}
+	if v.Count == 0 {
+		v.Count = 1
+	}
+	if v.FirstSeen.IsZero() {
+		v.FirstSeen = v.LastSeen
+	}
+	return v
+}
+
+// listEvents returns the events matching f, newest first. Kind, name and type are
+// filtered by the apiserver, the time window and match locally.
+func listEvents(ctx context.Context, cs kubernetes.Interface, f eventFilter) ([]eventView, error) {
+	sel := fields.Set{}
+	if f.kind != "" {
+		sel["involvedObject.kind"] = f.kind
+	}
+	if f.name != "" {
+		sel["involvedObject.name"] = f.name
+	}
+	if f.eventType != "" {
+		sel["type"] = f.eventType
+	}
+	list, err := cs.CoreV1().Events(f.namespace).List(ctx, metav1.ListOptions{FieldSelector: sel.AsSelector().String()})
+	if err != nil {
+		return nil, err
+	}
+
+	events := []eventView{}
+	for i := range list.Items {
+		e := &list.Items[i]
+		if !f.since.IsZero() && eventTime(e).Before(f.since) {
+			continue
+		}
+		if f.match != nil && !f.match(e.InvolvedObject.Kind, e.InvolvedObject.Name) {
+			continue
+		}
+		events = append(events, newEventView(e))
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].LastSeen.After(events[j].LastSeen) })
+	if f.limit > 0 && len(events) > f.limit {
+		events = events[:f.limit]
+	}
+	return events, nil
+}
+
+// workloadEvents returns the recent events of a workload and of its pods.
+func workloadEvents(ctx context.Context, cs kubernetes.Interface, namespace, kind, name string, pods []string) ([]eventView, error) {
+	podSet := make(map[string]bool, len(pods))
+	for _, p := range pods {
+		podSet[p] = true
+	}
+	return listEvents(ctx, cs, eventFilter{
+		namespace: namespace,
+		limit:     relatedEventLimit,
+		match: func(k, n string) bool {
+			return (k == kind && n == name) || (k == "Pod" && podSet[n])
+		},
+	})
+}
+
+// GetEvents lists events. Filters: ns (all namespaces when omitted, which needs a
+// grant on "*"), kind and name of the involved object, type (Normal or Warning), a
+// time window through sinceSeconds or since (RFC 3339), and limit (default 100).
+func GetEvents(c *gin.Context) {
+	f := eventFilter{
+		namespace: c.Query("ns"),
+		kind:      c.Query("kind"),
+		name:      c.Query("name"),
+		eventType: c.Query("type"),
+		limit:     defaultEventLimit,
+	}
+	if f.eventType != "" && f.eventType != corev1.EventTypeNormal && f.eventType != corev1.EventTypeWarning {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be Normal or Warning"})
+		return
+	}
+	if v := c.Query("sinceSeconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sinceSeconds must be a positive integer"})
+			return
+		}
+		f.since = time.Now().Add(-time.Duration(n) * time.Second)
+	} else if v := c.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 time"})
+			return
+		}
+		f.since = t
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		f.limit = n
+	}
+	if f.limit > maxEventLimit {
+		f.limit = maxEventLimit
+	}
+
+	scope := f.namespace
+	if scope == "" {
+		scope = models.AllNamespaces
+	}
+	if !authorizeNamespace(c, scope, models.VerbList) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	events, err := listEvents(context.TODO(), cs, f)
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
@@ -0,0 +1,237 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"gin-demo/models"
+)
+
+// defaultExecCommand starts bash when the image has it and falls back to sh.
+var defaultExecCommand = []string{"/bin/sh", "-c", "TERM=xterm-256color; export TERM; [ -x /bin/bash ] && exec /bin/bash || exec /bin/sh"}
+
+// execUpgrader upgrades exec requests; the default CheckOrigin only accepts same-origin pages.
+var execUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// execMessage is sent by the browser: {"type":"stdin","data":"ls\r"} or {"type":"resize","cols":120,"rows":40}.
+type execMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+// execSession bridges a browser WebSocket and a remotecommand stream. Container output
+// is written back as binary messages.
+type execSession struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	stdinR  *io.PipeReader
+	stdinW  *io.PipeWriter
+	sizes   chan remotecommand.TerminalSize
+
+	bytesIn  int64
+	bytesOut int64
+}
+
+func newExecSession(conn *websocket.Conn) *execSession {
+	r, w := io.Pipe()
+	return &execSession{conn: conn, stdinR: r, stdinW: w, sizes: make(chan remotecommand.TerminalSize, 1)}
+}
+
+func (s *execSession) Read(p []byte) (int, error) {
+	return s.stdinR.Read(p)
+}
+
+func (s *execSession) Write(p []byte) (int, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	atomic.AddInt64(&s.bytesOut, int64(len(p)))
+	return len(p), nil
+}
+
+// Next implements remotecommand.TerminalSizeQueue.
+func (s *execSession) Next() *remotecommand.TerminalSize {
+	size, ok := <-s.sizes
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// readLoop feeds browser messages into stdin and the resize queue until the socket closes.
+func (s *execSession) readLoop(cancel context.CancelFunc) {
+	defer cancel()
+	defer close(s.sizes)
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			_ = s.stdinW.CloseWithError(io.EOF)
+			return
+		}
+		var msg execMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "stdin":
+			atomic.AddInt64(&s.bytesIn, int64(len(msg.Data)))
+			if _, err := s.stdinW.Write([]byte(msg.Data)); err != nil {
+				return
+			}
+		case "resize":
+			if msg.Cols == 0 || msg.Rows == 0 {
+				continue
+			}
+			// keep only the latest size if the executor has not consumed the previous one
+			select {
+			case <-s.sizes:
+			default:
+			}
+			s.sizes <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
+		}
+	}
+}
+
+func (s *execSession) close(code int, reason string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	_ = s.conn.Close()
+}
+
+// ExecPod opens an interactive exec session into a pod container over WebSocket.
+// Query: ns, name, container, command (repeatable, defaults to a shell), tty (default true).
+// Every session is recorded in the audit log.
+func ExecPod(c *gin.Context) {
+	namespace := c.Query("ns")
+	name := c.Query("name")
+	if namespace == "" || name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbExec) {
+		return
+	}
+
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	pod, err := cl.client.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	container := c.Query("container")
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+	command := c.QueryArray("command")
+	if len(command) == 0 {
+		command = defaultExecCommand
+	}
+	tty := c.Query("tty") != "false"
+
+	req := cl.client.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(name).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    !tty,
+			TTY:       tty,
+		}, scheme.ParameterCodec)
+	if cl.config == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "exec is not available on cluster " + cl.name})
+		return
+	}
+	executor, err := remotecommand.NewSPDYExecutor(cl.config, http.MethodPost, req.URL())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, err := execUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader already wrote an HTTP error
+		logrus.Warnf("k8s: exec upgrade failed: %v", err)
+		return
+	}
+
+	entry := &models.AuditLog{
+		User:      currentUser(c),
+		Cluster:   cl.name,
+		Namespace: namespace,
+		Kind:      "Pod",
+		Name:      name,
+		Action:    "exec",
+		Result:    "started",
+		ClientIP:  c.ClientIP(),
+		Detail:    fmt.Sprintf("container=%s command=%q tty=%t", container, strings.Join(command, " "), tty),
+	}
+	if err := models.CreateAuditLog(entry); err != nil {
+		// refuse unaudited sessions
+		logrus.Errorf("k8s: exec audit failed: %v", err)
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "audit unavailable"), time.Now().Add(time.Second))
+		_ = conn.Close()
+		return
+	}
+	logrus.Infof("k8s: exec session %d user=%s pod=%s/%s container=%s", entry.ID, entry.User, namespace, name, container)
+
+	sess := newExecSession(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sess.readLoop(cancel)
+
+	started := time.Now()
+	opts := remotecommand.StreamOptions{Stdin: sess, Stdout: sess, Tty: tty}
+	if tty {
+		opts.TerminalSizeQueue = sess
+	} else {
+		opts.Stderr = sess
+	}
+	err = executor.StreamWithContext(ctx, opts)
+
+	result := "completed"
+	closeCode, closeReason := websocket.CloseNormalClosure, "session ended"
+	if err != nil && ctx.Err() == nil {
+		result = "error: " + err.Error()
+		closeCode, closeReason = websocket.CloseInternalServerErr, err.Error()
+	}
+	if len(closeReason) > 120 {
+		// close frame payloads are limited to 125 bytes
+		closeReason = closeReason[:120]
+	}
+	sess.close(closeCode, closeReason)
+
+	detail := fmt.Sprintf("%s duration=%s bytes_in=%d bytes_out=%d", entry.Detail, time.Since(started).Round(time.Second),
+		atomic.LoadInt64(&sess.bytesIn), atomic.LoadInt64(&sess.bytesOut))
+	if len(result) > 255 {
+		result = result[:255]
+	}
+	if err := models.FinishAuditLog(entry.ID, result, detail); err != nil {
+		logrus.Errorf("k8s: exec audit finish failed session=%d: %v", entry.ID, err)
+	}
+	logrus.Infof("k8s: exec session %d finished: %s", entry.ID, result)
+}
@@ -0,0 +1,1317 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"gin-demo/models"
+)
+
+const (
+	testCluster   = "test"
+	testNamespace = "default"
+	testName      = "demo"
+
+	// users created by newTestDB
+	userAdmin    = "admin"    // admin role: every permission and namespace
+	userOperator = "operator" // read and write on testNamespace only
+	userViewer   = "viewer"   // read permission without any namespace grant
+)
+
+// newTestDB installs an in-memory database with the built-in roles and test users.
+func newTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: opens a new database
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.User{}, &models.Permission{}, &models.Role{}, &models.NamespaceGrant{},
+		&models.AuditLog{}, &models.ProtectedNamespace{}, &models.ChangeRequest{}, &models.Cluster{}); err != nil {
+		t.Fatal(err)
+	}
+	models.InitDB(db)
+	t.Cleanup(func() {
+		models.DB = nil
+		_ = sqlDB.Close()
+	})
+	if err := models.SeedRoles(); err != nil {
+		t.Fatal(err)
+	}
+	for user, role := range map[string]string{userAdmin: models.RoleAdmin, userOperator: models.RoleK8sOperator, userViewer: models.RoleViewer} {
+		if err := models.CreateUser(user, user+"@example.com", "secret"); err != nil {
+			t.Fatal(err)
+		}
+		if err := models.SetUserRoles(user, []string{role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := models.CreateNamespaceGrant(models.SubjectUser, userOperator, models.AllNamespaces, testNamespace, []string{models.AllNamespaces}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// honourDryRun keeps objects unchanged on dry-run patches, which the fake object
+// tracker otherwise applies like real ones.
+func honourDryRun(cs *fake.Clientset) {
+	cs.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch, ok := action.(k8stesting.PatchActionImpl)
+		if !ok || len(patch.PatchOptions.DryRun) == 0 {
+			return false, nil, nil
+		}
+		gvr, ns := patch.GetResource(), patch.GetNamespace()
+		before, err := cs.Tracker().Get(gvr, ns, patch.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		patch.PatchOptions.DryRun = nil
+		_, obj, err := k8stesting.ObjectReaction(cs.Tracker())(patch)
+		if restoreErr := cs.Tracker().Update(gvr, before, ns, metav1.UpdateOptions{FieldManager: "test"}); restoreErr != nil {
+			return true, nil, restoreErr
+		}
+		return true, obj, err
+	})
+}
+
+// testMails records the notifications sent through mailSend.
+type testMails struct {
+	mu   sync.Mutex
+	sent map[string][]string // recipient to subjects
+}
+
+func (m *testMails) to(addr string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sent[addr]
+}
+
+// waitFor polls until addr got n mails; they are sent asynchronously.
+func (m *testMails) waitFor(t *testing.T, addr string, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := m.to(addr); len(got) >= n {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d mails to %s, got %v", n, addr, m.to(addr))
+	return nil
+}
+
+type testEnv struct {
+	router *gin.Engine
+	cs     *fake.Clientset
+	mails  *testMails
+}
+
+// newTestEnv serves the kubernetes routes from a fake clientset holding objects. The
+// user of a request is taken from the X-Test-User header.
+func newTestEnv(t *testing.T, objects ...runtime.Object) *testEnv {
+	t.Helper()
+	newTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	cs := fake.NewClientset(objects...)
+	honourDryRun(cs)
+	mails := &testMails{sent: map[string][]string{}}
+	prevSend := mailSend
+	mailSend = func(to, subject, body string) error {
+		mails.mu.Lock()
+		defer mails.mu.Unlock()
+		mails.sent[to] = append(mails.sent[to], subject)
+		return nil
+	}
+	t.Cleanup(func() { mailSend = prevSend })
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user", c.GetHeader("X-Test-User"))
+		c.Next()
+	})
+	RegisterRoutes(r.Group("/api/k8s"), WithClients(testCluster, cs, dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())))
+	t.Cleanup(func() {
+		if cl, ok := unregisterCluster(testCluster); ok {
+			cl.stopCache()
+		}
+	})
+	return &testEnv{router: r, cs: cs, mails: mails}
+}
+
+// do sends a request as user with form as the urlencoded body.
+func (e *testEnv) do(method, target, user string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	req.Header.Set("X-Test-User", user)
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+// live returns the object of kind named name from the fake clientset.
+func (e *testEnv) live(t *testing.T, kind, name string) metav1.Object {
+	t.Helper()
+	obj, err := typedKinds[kind].client(e.cs, testNamespace).get(context.TODO(), name)
+	if err != nil {
+		t.Fatalf("get %s %s: %v", kind, name, err)
+	}
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, w.Body.String())
+	}
+	return body
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+}
+
+func testMeta() metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: testName, Namespace: testNamespace, ResourceVersion: "1", Labels: map[string]string{"app": testName}}
+}
+
+func testPodTemplate() corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": testName}},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyAlways,
+			Containers:    []corev1.Container{{Name: "app", Image: "nginx:1.27"}},
+		},
+	}
+}
+
+// testObjects returns one object named testName per typed kind with a list page.
+func testObjects() map[string]runtime.Object {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": testName}}
+	jobTemplate := testPodTemplate()
+	jobTemplate.Spec.RestartPolicy = corev1.RestartPolicyNever
+	pathType := networkingv1.PathTypePrefix
+	replicas := int32(1)
+	return map[string]runtime.Object{
+		"deployments": &appsv1.Deployment{ObjectMeta: testMeta(), Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas, Selector: selector, Template: testPodTemplate()}},
+		"daemonsets": &appsv1.DaemonSet{ObjectMeta: testMeta(), Spec: appsv1.DaemonSetSpec{
+			Selector: selector, Template: testPodTemplate()}},
+		"statefulsets": &appsv1.StatefulSet{ObjectMeta: testMeta(), Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas, Selector: selector, ServiceName: testName, Template: testPodTemplate()}},
+		"jobs": &batchv1.Job{ObjectMeta: testMeta(), Spec: batchv1.JobSpec{Template: jobTemplate}},
+		"cronjobs": &batchv1.CronJob{ObjectMeta: testMeta(), Spec: batchv1.CronJobSpec{
+			Schedule: "*/5 * * * *", JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: jobTemplate}}}},
+		"services": &corev1.Service{ObjectMeta: testMeta(), Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": testName}, Ports: []corev1.ServicePort{{Name: "http", Port: 80}}}},
+		"configmaps": &corev1.ConfigMap{ObjectMeta: testMeta(), Data: map[string]string{"key": "value"}},
+		"secrets": &corev1.Secret{ObjectMeta: testMeta(), Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{"password": []byte("s3cret")}},
+		"ingresses": &networkingv1.Ingress{ObjectMeta: testMeta(), Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{Host: "demo.example.com", IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{
+					Path: "/", PathType: &pathType, Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+						Name: testName, Port: networkingv1.ServiceBackendPort{Number: 80}}},
+				}}},
+			}}}}},
+		"persistentvolumeclaims": &corev1.PersistentVolumeClaim{ObjectMeta: testMeta(), Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1Gi")}}}},
+		"horizontalpodautoscalers": &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: testMeta(), Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: testName},
+			MaxReplicas:    3}},
+		"networkpolicies": &networkingv1.NetworkPolicy{ObjectMeta: testMeta(), Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}}},
+	}
+}
+
+func testObjectList() []runtime.Object {
+	var objs []runtime.Object
+	for _, obj := range testObjects() {
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+// editedYAML loads kind through the YAML endpoint in format and adds the label
+// edited=true, like a user editing it in the browser.
+func (e *testEnv) editedYAML(t *testing.T, kind string, format ...string) string {
+	t.Helper()
+	target := "/api/k8s/" + kind + "/yaml?ns=" + testNamespace + "&name=" + testName + "&strip=managedFields,status"
+	if len(format) > 0 {
+		target += "&format=" + format[0]
+	}
+	w := e.do(http.MethodGet, target, userAdmin, nil)
+	expectStatus(t, w, http.StatusOK)
+	var obj map[string]interface{}
+	if err := sigsyaml.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+		t.Fatalf("invalid YAML: %v: %s", err, w.Body.String())
+	}
+	md := obj["metadata"].(map[string]interface{})
+	labels, _ := md["labels"].(map[string]interface{})
+	if labels == nil {
+		labels = map[string]interface{}{}
+	}
+	labels["edited"] = "true"
+	md["labels"] = labels
+	data, err := sigsyaml.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestListKinds(t *testing.T) {
+	for kind := range testObjects() {
+		t.Run(kind, func(t *testing.T) {
+			env := newTestEnv(t, testObjectList()...)
+			w := env.do(http.MethodGet, "/api/k8s/"+kind+"?fresh=true&ns="+testNamespace, userOperator, nil)
+			expectStatus(t, w, http.StatusOK)
+			items, ok := decodeJSON(t, w)[kind].([]interface{})
+			if !ok || len(items) != 1 {
+				t.Fatalf("expected one %s, got %s", kind, w.Body.String())
+			}
+			item := items[0].(map[string]interface{})
+			name := item["name"]
+			if md, ok := item["metadata"].(map[string]interface{}); ok {
+				name = md["name"]
+			}
+			if name != testName {
+				t.Fatalf("expected %s, got %v", testName, name)
+			}
+		})
+	}
+}
+
+func TestListKindsErrors(t *testing.T) {
+	for kind := range testObjects() {
+		t.Run(kind, func(t *testing.T) {
+			env := newTestEnv(t, testObjectList()...)
+			base := "/api/k8s/" + kind + "?fresh=true"
+			expectStatus(t, env.do(http.MethodGet, base, userAdmin, nil), http.StatusBadRequest)
+			expectStatus(t, env.do(http.MethodGet, base+"&ns="+testNamespace, "", nil), http.StatusUnauthorized)
+			expectStatus(t, env.do(http.MethodGet, base+"&ns="+testNamespace, userViewer, nil), http.StatusForbidden)
+			expectStatus(t, env.do(http.MethodGet, base+"&ns=other", userOperator, nil), http.StatusForbidden)
+			expectStatus(t, env.do(http.MethodGet, base+"&ns="+testNamespace+"&cluster=missing", userAdmin, nil), http.StatusNotFound)
+
+			env.cs.PrependReactor("list", kind, func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewInternalError(errors.New("etcd unavailable"))
+			})
+			w := env.do(http.MethodGet, base+"&ns="+testNamespace, userAdmin, nil)
+			expectStatus(t, w, http.StatusInternalServerError)
+			if !strings.Contains(decodeJSON(t, w)["error"].(string), "etcd unavailable") {
+				t.Fatalf("expected the apiserver error, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListFromCache(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := env.do(http.MethodGet, "/api/k8s/deployments?ns="+testNamespace, userAdmin, nil)
+		expectStatus(t, w, http.StatusOK)
+		body := decodeJSON(t, w)
+		if body["source"] == sourceCache {
+			if items := body["deployments"].([]interface{}); len(items) != 1 {
+				t.Fatalf("expected one cached deployment, got %s", w.Body.String())
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("informer cache did not sync, last response %s", w.Body.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestGetYAML(t *testing.T) {
+	for kind := range testObjects() {
+		t.Run(kind, func(t *testing.T) {
+			env := newTestEnv(t, testObjectList()...)
+			base := "/api/k8s/" + kind + "/yaml?ns=" + testNamespace
+			w := env.do(http.MethodGet, base+"&name="+testName, userOperator, nil)
+			expectStatus(t, w, http.StatusOK)
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/yaml") {
+				t.Fatalf("expected YAML, got %s", ct)
+			}
+			var obj map[string]interface{}
+			if err := sigsyaml.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+				t.Fatalf("invalid YAML: %v: %s", err, w.Body.String())
+			}
+			apiVersion, kindName := typedKinds[kind].gvk.ToAPIVersionAndKind()
+			if obj["apiVersion"] != apiVersion || obj["kind"] != kindName {
+				t.Fatalf("expected %s %s, got %v %v", apiVersion, kindName, obj["apiVersion"], obj["kind"])
+			}
+			md := obj["metadata"].(map[string]interface{})
+			if md["name"] != testName || md["resourceVersion"] != "1" {
+				t.Fatalf("unexpected metadata %v", md)
+			}
+			// JSON field names, not the lowercased Go ones
+			if _, ok := obj["objectmeta"]; ok {
+				t.Fatalf("YAML uses Go field names: %s", w.Body.String())
+			}
+
+			w = env.do(http.MethodGet, base+"&name="+testName+"&format=json", userOperator, nil)
+			expectStatus(t, w, http.StatusOK)
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Fatalf("expected JSON, got %s", ct)
+			}
+			if body := decodeJSON(t, w); body["kind"] != kindName {
+				t.Fatalf("expected kind %s in JSON, got %v", kindName, body["kind"])
+			}
+
+			expectStatus(t, env.do(http.MethodGet, base+"&name="+testName+"&format=xml", userOperator, nil), http.StatusBadRequest)
+			expectStatus(t, env.do(http.MethodGet, base+"&name="+testName+"&strip=spec", userOperator, nil), http.StatusBadRequest)
+			expectStatus(t, env.do(http.MethodGet, base+"&name=missing", userOperator, nil), http.StatusNotFound)
+			expectStatus(t, env.do(http.MethodGet, base, userOperator, nil), http.StatusBadRequest)
+			expectStatus(t, env.do(http.MethodGet, base+"&name="+testName, userViewer, nil), http.StatusForbidden)
+		})
+	}
+}
+
+func TestGetSecretYAMLMasked(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	base := "/api/k8s/secrets/yaml?ns=" + testNamespace + "&name=" + testName
+	w := env.do(http.MethodGet, base, userOperator, nil)
+	expectStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), "czNjcmV0") || !strings.Contains(w.Body.String(), maskedValue) {
+		t.Fatalf("secret value not masked: %s", w.Body.String())
+	}
+	// revealing needs k8s:secrets, which the operator role lacks
+	expectStatus(t, env.do(http.MethodGet, base+"&reveal=true", userOperator, nil), http.StatusForbidden)
+	w = env.do(http.MethodGet, base+"&reveal=true", userAdmin, nil)
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "czNjcmV0") {
+		t.Fatalf("secret value not revealed: %s", w.Body.String())
+	}
+}
+
+func TestGetYAMLStrip(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	// give the deployment managed fields and a status to strip
+	form := url.Values{"yaml": {env.editedYAML(t, "deployments")}}
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/deployments/update?ns="+testNamespace+"&name="+testName+"&confirm=true", userAdmin, form), http.StatusOK)
+	dep, err := env.cs.AppsV1().Deployments(testNamespace).Get(context.TODO(), testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep.Status.Replicas = 1
+	if _, err := env.cs.AppsV1().Deployments(testNamespace).UpdateStatus(context.TODO(), dep, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	base := "/api/k8s/deployments/yaml?format=json&ns=" + testNamespace + "&name=" + testName
+	full := decodeJSON(t, env.do(http.MethodGet, base, userAdmin, nil))
+	if _, ok := full["status"]; !ok {
+		t.Fatal("status missing without strip")
+	}
+	if _, ok := full["metadata"].(map[string]interface{})["managedFields"]; !ok {
+		t.Fatal("managedFields missing without strip")
+	}
+	stripped := decodeJSON(t, env.do(http.MethodGet, base+"&strip=managedFields,status", userAdmin, nil))
+	if _, ok := stripped["status"]; ok {
+		t.Fatal("status not stripped")
+	}
+	if _, ok := stripped["metadata"].(map[string]interface{})["managedFields"]; ok {
+		t.Fatal("managedFields not stripped")
+	}
+	if stripped["spec"] == nil {
+		t.Fatal("spec stripped")
+	}
+}
+
+func TestUpdateFromJSON(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	doc := env.editedYAML(t, "deployments", formatJSON)
+	data, err := sigsyaml.YAMLToJSON([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"yaml": {string(data)}}
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/deployments/update?ns="+testNamespace+"&name="+testName+"&confirm=true", userOperator, form), http.StatusOK)
+	if env.live(t, "deployments", testName).GetLabels()["edited"] != "true" {
+		t.Fatal("JSON update was not applied")
+	}
+}
+
+func TestUpdateKinds(t *testing.T) {
+	for kind := range testObjects() {
+		t.Run(kind, func(t *testing.T) {
+			env := newTestEnv(t, testObjectList()...)
+			target := "/api/k8s/" + kind + "/update?ns=" + testNamespace + "&name=" + testName
+			form := url.Values{"yaml": {env.editedYAML(t, kind)}}
+
+			w := env.do(http.MethodPost, target, userOperator, form)
+			expectStatus(t, w, http.StatusOK)
+			body := decodeJSON(t, w)
+			if body["dryRun"] != true {
+				t.Fatalf("expected a preview, got %s", w.Body.String())
+			}
+			found := false
+			for _, ch := range body["diff"].([]interface{}) {
+				if ch.(map[string]interface{})["path"] == "metadata.labels.edited" {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("diff misses the new label: %s", w.Body.String())
+			}
+			if _, ok := env.live(t, kind, testName).GetLabels()["edited"]; ok {
+				t.Fatal("preview changed the object")
+			}
+
+			w = env.do(http.MethodPost, target+"&confirm=true", userOperator, form)
+			expectStatus(t, w, http.StatusOK)
+			if decodeJSON(t, w)["message"] != "updated" {
+				t.Fatalf("expected updated, got %s", w.Body.String())
+			}
+			if env.live(t, kind, testName).GetLabels()["edited"] != "true" {
+				t.Fatal("confirmed update was not applied")
+			}
+		})
+	}
+}
+
+func TestUpdateSecretKeepsMaskedValues(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	target := "/api/k8s/secrets/update?ns=" + testNamespace + "&name=" + testName + "&confirm=true"
+	w := env.do(http.MethodPost, target, userOperator, url.Values{"yaml": {env.editedYAML(t, "secrets")}})
+	expectStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), "czNjcmV0") {
+		t.Fatalf("diff shows the secret value: %s", w.Body.String())
+	}
+	secret, err := env.cs.CoreV1().Secrets(testNamespace).Get(context.TODO(), testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["password"]) != "s3cret" {
+		t.Fatalf("masked value was not kept, got %q", secret.Data["password"])
+	}
+}
+
+func TestUpdateKindsErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		user   string
+		query  string
+		yaml   func(valid string) string
+		status int
+	}{
+		{"missing yaml", userOperator, "", func(string) string { return "" }, http.StatusBadRequest},
+		{"invalid yaml", userOperator, "", func(string) string { return "metadata: [" }, http.StatusBadRequest},
+		{"list instead of object", userOperator, "", func(string) string { return "- a\n- b\n" }, http.StatusBadRequest},
+		{"kind mismatch", userOperator, "", func(v string) string { return replaceTop(v, "kind", "Unknown") }, http.StatusBadRequest},
+		{"name mismatch", userOperator, "", func(v string) string { return replaceMeta(v, "name", "other") }, http.StatusBadRequest},
+		{"stale resourceVersion", userOperator, "", func(v string) string { return replaceMeta(v, "resourceVersion", "0") }, http.StatusConflict},
+		{"object not found", userOperator, "&name=missing", func(v string) string { return replaceMeta(v, "name", "missing") }, http.StatusNotFound},
+		{"namespace not granted", userViewer, "", func(v string) string { return v }, http.StatusForbidden},
+		{"unknown cluster", userOperator, "&cluster=missing", func(v string) string { return v }, http.StatusNotFound},
+	}
+	for kind := range testObjects() {
+		t.Run(kind, func(t *testing.T) {
+			env := newTestEnv(t, testObjectList()...)
+			valid := env.editedYAML(t, kind)
+			for _, tc := range cases {
+				target := "/api/k8s/" + kind + "/update?ns=" + testNamespace + "&confirm=true"
+				if !strings.Contains(tc.query, "&name=") {
+					target += "&name=" + testName
+				}
+				w := env.do(http.MethodPost, target+tc.query, tc.user, url.Values{"yaml": {tc.yaml(valid)}})
+				if w.Code != tc.status {
+					t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.status, w.Code, w.Body.String())
+				}
+			}
+			if _, ok := env.live(t, kind, testName).GetLabels()["edited"]; ok {
+				t.Fatal("a failed update changed the object")
+			}
+		})
+	}
+}
+
+func TestUpdateForbiddenWithoutWritePermission(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	// a namespace grant alone is not enough without k8s:write
+	if _, err := models.CreateNamespaceGrant(models.SubjectUser, userViewer, models.AllNamespaces, testNamespace, []string{models.AllNamespaces}); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"yaml": {env.editedYAML(t, "configmaps")}}
+	w := env.do(http.MethodPost, "/api/k8s/configmaps/update?ns="+testNamespace+"&name="+testName+"&confirm=true", userViewer, form)
+	expectStatus(t, w, http.StatusForbidden)
+	if decodeJSON(t, w)["permission"] != models.PermK8sWrite {
+		t.Fatalf("expected the k8s:write permission to be named, got %s", w.Body.String())
+	}
+}
+
+// replaceTop sets a top-level field of a YAML document.
+func replaceTop(doc, key, value string) string {
+	var obj map[string]interface{}
+	_ = sigsyaml.Unmarshal([]byte(doc), &obj)
+	obj[key] = value
+	data, _ := sigsyaml.Marshal(obj)
+	return string(data)
+}
+
+// replaceMeta sets a metadata field of a YAML document.
+func replaceMeta(doc, key, value string) string {
+	var obj map[string]interface{}
+	_ = sigsyaml.Unmarshal([]byte(doc), &obj)
+	obj["metadata"].(map[string]interface{})[key] = value
+	data, _ := sigsyaml.Marshal(obj)
+	return string(data)
+}
+
+// requestProtectedChange protects testNamespace and submits a label change to the
+// deployment as the operator; it returns the change request ID.
+func (e *testEnv) requestProtectedChange(t *testing.T) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/k8s/protected", strings.NewReader(`{"namespace":"`+testNamespace+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", userAdmin)
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	expectStatus(t, w, http.StatusCreated)
+
+	form := url.Values{"yaml": {e.editedYAML(t, "deployments")}}
+	w = e.do(http.MethodPost, "/api/k8s/deployments/update?ns="+testNamespace+"&name="+testName+"&confirm=true", userOperator, form)
+	expectStatus(t, w, http.StatusAccepted)
+	if _, ok := e.live(t, "deployments", testName).GetLabels()["edited"]; ok {
+		t.Fatal("change to a protected namespace was applied without approval")
+	}
+	cr := decodeJSON(t, w)["changeRequest"].(map[string]interface{})
+	if cr["status"] != models.ChangePending || cr["requester"] != userOperator {
+		t.Fatalf("unexpected change request %v", cr)
+	}
+	e.mails.waitFor(t, userAdmin+"@example.com", 1)
+	return strconv.Itoa(int(cr["ID"].(float64)))
+}
+
+func TestProtectedNamespaceApproval(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	id := env.requestProtectedChange(t)
+
+	w := env.do(http.MethodGet, "/api/k8s/changes?status=pending", userOperator, nil)
+	expectStatus(t, w, http.StatusOK)
+	if changes := decodeJSON(t, w)["changes"].([]interface{}); len(changes) != 1 {
+		t.Fatalf("expected one pending change, got %s", w.Body.String())
+	}
+
+	// the requester holds neither k8s:approve nor may approve their own change
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/approve", userOperator, url.Values{}), http.StatusForbidden)
+
+	w = env.do(http.MethodPost, "/api/k8s/changes/"+id+"/approve", userAdmin, url.Values{"comment": {"ok"}})
+	expectStatus(t, w, http.StatusOK)
+	if env.live(t, "deployments", testName).GetLabels()["edited"] != "true" {
+		t.Fatal("approved change was not applied")
+	}
+	env.mails.waitFor(t, userOperator+"@example.com", 1)
+
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/approve", userAdmin, url.Values{}), http.StatusConflict)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/999/approve", userAdmin, url.Values{}), http.StatusNotFound)
+}
+
+func TestProtectedNamespaceDrift(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	id := env.requestProtectedChange(t)
+
+	// someone else changes the deployment before the approval
+	dep, err := env.cs.AppsV1().Deployments(testNamespace).Get(context.TODO(), testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep.ResourceVersion = "2"
+	if _, err := env.cs.AppsV1().Deployments(testNamespace).Update(context.TODO(), dep, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := env.do(http.MethodPost, "/api/k8s/changes/"+id+"/approve", userAdmin, url.Values{})
+	expectStatus(t, w, http.StatusConflict)
+	if _, ok := env.live(t, "deployments", testName).GetLabels()["edited"]; ok {
+		t.Fatal("drifted change was applied")
+	}
+	w = env.do(http.MethodGet, "/api/k8s/changes", userAdmin, nil)
+	expectStatus(t, w, http.StatusOK)
+	cr := decodeJSON(t, w)["changes"].([]interface{})[0].(map[string]interface{})
+	if cr["status"] != models.ChangeConflict {
+		t.Fatalf("expected status %s, got %v", models.ChangeConflict, cr["status"])
+	}
+}
+
+func TestProtectedNamespaceReject(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	id := env.requestProtectedChange(t)
+
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/reject", userViewer, url.Values{}), http.StatusForbidden)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/reject", userAdmin, url.Values{"comment": {"not now"}}), http.StatusOK)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/approve", userAdmin, url.Values{}), http.StatusConflict)
+	if _, ok := env.live(t, "deployments", testName).GetLabels()["edited"]; ok {
+		t.Fatal("rejected change was applied")
+	}
+}
+
+func TestProtectedNamespaceWithdraw(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	id := env.requestProtectedChange(t)
+
+	// rejecting needs k8s:approve, withdrawing is for the requester only
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/reject", userOperator, url.Values{}), http.StatusForbidden)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/withdraw", userAdmin, url.Values{}), http.StatusForbidden)
+	w := env.do(http.MethodPost, "/api/k8s/changes/"+id+"/withdraw", userOperator, url.Values{})
+	expectStatus(t, w, http.StatusOK)
+	if cr := decodeJSON(t, w)["changeRequest"].(map[string]interface{}); cr["status"] != models.ChangeWithdrawn {
+		t.Fatalf("expected status %s, got %v", models.ChangeWithdrawn, cr["status"])
+	}
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/approve", userAdmin, url.Values{}), http.StatusConflict)
+
+	// lifting the protection needs k8s:protect
+	expectStatus(t, env.do(http.MethodDelete, "/api/k8s/protected/1", userOperator, nil), http.StatusForbidden)
+}
+
+// liveImages returns the container images of a workload by container name.
+func (e *testEnv) liveImages(t *testing.T, kind string) map[string]string {
+	t.Helper()
+	obj, err := typedKinds[kind].client(e.cs, testNamespace).get(context.TODO(), testName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, _ := podSpecOf(obj)
+	images := map[string]string{}
+	for _, ct := range append(spec.InitContainers, spec.Containers...) {
+		images[ct.Name] = ct.Image
+	}
+	return images
+}
+
+func TestProtectedNamespaceActions(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace}, Spec: corev1.PodSpec{NodeName: node.Name}}
+	env := newTestEnv(t, append(testObjectList(), node, pod)...)
+	if _, err := models.CreateProtectedNamespace(models.AllNamespaces, testNamespace); err != nil {
+		t.Fatal(err)
+	}
+	query := "?ns=" + testNamespace + "&name=" + testName
+	approve := func(w *httptest.ResponseRecorder) {
+		t.Helper()
+		expectStatus(t, w, http.StatusAccepted)
+		cr := decodeJSON(t, w)["changeRequest"].(map[string]interface{})
+		if cr["patchType"] != string(types.StrategicMergePatchType) {
+			t.Fatalf("expected a strategic merge patch, got %v", cr)
+		}
+		expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+strconv.Itoa(int(cr["ID"].(float64)))+"/approve", userAdmin, url.Values{}), http.StatusOK)
+	}
+
+	// patches wait for approval
+	approve(env.do(http.MethodPost, "/api/k8s/deployments/scale"+query+"&replicas=3", userOperator, url.Values{}))
+	dep, err := env.cs.AppsV1().Deployments(testNamespace).Get(context.TODO(), testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dep.Spec.Replicas != 3 {
+		t.Fatalf("approved scale not applied: %d replicas", *dep.Spec.Replicas)
+	}
+	w := env.do(http.MethodPost, "/api/k8s/deployments/restart"+query, userOperator, url.Values{})
+	if _, ok := env.live(t, "deployments", testName).GetAnnotations()[restartedAtAnnotation]; ok {
+		t.Fatal("restart applied without approval")
+	}
+	approve(w)
+	approve(env.do(http.MethodPost, "/api/k8s/cronjobs/suspend"+query, userOperator, url.Values{}))
+	cj, err := env.cs.BatchV1().CronJobs(testNamespace).Get(context.TODO(), testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cj.Spec.Suspend == nil || !*cj.Spec.Suspend {
+		t.Fatal("approved suspend not applied")
+	}
+
+	// deletes and creates cannot wait for approval
+	expectStatus(t, env.do(http.MethodDelete, "/api/k8s/configmaps"+query+"&confirm="+testName, userOperator, nil), http.StatusConflict)
+	env.live(t, "configmaps", testName)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/jobs/cleanup?ns="+testNamespace, userOperator, url.Values{}), http.StatusConflict)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/jobs/cleanup?ns="+testNamespace+"&dryRun=true", userOperator, url.Values{}), http.StatusOK)
+	manifest := url.Values{"manifest": {"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: extra\n"}}
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/manifests?ns="+testNamespace, userOperator, manifest), http.StatusConflict)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/manifests?ns="+testNamespace+"&dryRun=true", userOperator, manifest), http.StatusCreated)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/configmaps/create?ns="+testNamespace, userOperator,
+		url.Values{"yaml": {"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: direct\n"}}), http.StatusConflict)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/secrets/create?ns="+testNamespace, userOperator,
+		url.Values{"yaml": {"apiVersion: v1\nkind: Secret\nmetadata:\n  name: direct\nstringData:\n  key: value\n"}}), http.StatusConflict)
+	if _, err := env.cs.CoreV1().ConfigMaps(testNamespace).Get(context.TODO(), "direct", metav1.GetOptions{}); err == nil {
+		t.Fatal("configmap created in a protected namespace")
+	}
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/nodes/drain?name="+node.Name+"&force=true", userAdmin, url.Values{}), http.StatusConflict)
+	if n, err := env.cs.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{}); err != nil || n.Spec.Unschedulable {
+		t.Fatalf("node cordoned by a refused drain: %v", err)
+	}
+}
+
+// patchedObject previews JSON patches such as rollbacks for change requests.
+func TestPatchedObjectJSONPatch(t *testing.T) {
+	dep := testObjects()["deployments"]
+	patch := []byte(`[{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"nginx:1.26"}]`)
+	proposed, err := patchedObject(typedKinds["deployments"], dep, types.JSONPatchType, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img := proposed.(*appsv1.Deployment).Spec.Template.Spec.Containers[0].Image; img != "nginx:1.26" {
+		t.Fatalf("patch not applied: %s", img)
+	}
+}
+
+// patchManagers returns the field managers of the patches sent to the fake clientset
+// for resource.
+func (e *testEnv) patchManagers(resource string) []string {
+	var managers []string
+	for _, a := range e.cs.Actions() {
+		if p, ok := a.(k8stesting.PatchActionImpl); ok && p.GetResource().Resource == resource {
+			managers = append(managers, p.PatchOptions.FieldManager)
+		}
+	}
+	return managers
+}
+
+func TestWorkloadActionsFieldManager(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	query := "?ns=" + testNamespace + "&name=" + testName
+	for _, target := range []string{"/api/k8s/deployments/restart", "/api/k8s/deployments/pause", "/api/k8s/deployments/resume"} {
+		expectStatus(t, env.do(http.MethodPost, target+query, userOperator, url.Values{}), http.StatusOK)
+	}
+	managers := env.patchManagers("deployments")
+	if len(managers) != 3 {
+		t.Fatalf("expected 3 patches, got %v", managers)
+	}
+	for _, m := range managers {
+		if m != fieldManager {
+			t.Fatalf("expected field manager %s, got %v", fieldManager, managers)
+		}
+	}
+}
+
+func TestCordonFieldManager(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	env := newTestEnv(t, node)
+	for _, target := range []string{"/api/k8s/nodes/cordon", "/api/k8s/nodes/uncordon"} {
+		expectStatus(t, env.do(http.MethodPost, target+"?name="+node.Name, userAdmin, url.Values{}), http.StatusOK)
+	}
+	managers := env.patchManagers("nodes")
+	if len(managers) != 2 {
+		t.Fatalf("expected 2 patches, got %v", managers)
+	}
+	for _, m := range managers {
+		if m != fieldManager {
+			t.Fatalf("expected field manager %s, got %v", fieldManager, managers)
+		}
+	}
+}
+
+func TestCronJobActionsFieldManager(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	query := "?ns=" + testNamespace + "&name=" + testName
+	for _, target := range []string{"/api/k8s/cronjobs/suspend", "/api/k8s/cronjobs/resume", "/api/k8s/cronjobs/ttl"} {
+		expectStatus(t, env.do(http.MethodPost, target+query+"&seconds=3600", userOperator, url.Values{}), http.StatusOK)
+	}
+	cj, err := env.cs.BatchV1().CronJobs(testNamespace).Get(context.TODO(), testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := cj.Spec.JobTemplate.Spec.TTLSecondsAfterFinished; ttl == nil || *ttl != 3600 {
+		t.Fatalf("ttl not set: %v", ttl)
+	}
+	managers := env.patchManagers("cronjobs")
+	if len(managers) != 3 {
+		t.Fatalf("expected 3 patches, got %v", managers)
+	}
+	for _, m := range managers {
+		if m != fieldManager {
+			t.Fatalf("expected field manager %s, got %v", fieldManager, managers)
+		}
+	}
+}
+
+func TestRollbackDeployment(t *testing.T) {
+	var objs []runtime.Object
+	for kind, obj := range testObjects() {
+		if kind != "deployments" {
+			objs = append(objs, obj)
+		}
+	}
+	dep := testObjects()["deployments"].(*appsv1.Deployment)
+	dep.UID = "dep-uid"
+	dep.Annotations = map[string]string{revisionAnnotation: "2"}
+	isController := true
+	for rev, image := range map[string]string{"1": "nginx:1.26", "2": "nginx:1.27"} {
+		template := testPodTemplate()
+		template.Spec.Containers[0].Image = image
+		objs = append(objs, &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Name: testName + "-" + rev, Namespace: testNamespace, Labels: map[string]string{"app": testName},
+				Annotations:     map[string]string{revisionAnnotation: rev},
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: testName, UID: dep.UID, Controller: &isController}}},
+			Spec: appsv1.ReplicaSetSpec{Selector: dep.Spec.Selector, Template: template},
+		})
+	}
+	env := newTestEnv(t, append(objs, dep)...)
+
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/deployments/rollback?ns="+testNamespace+"&name="+testName, userOperator, url.Values{}), http.StatusOK)
+	if img := env.liveImages(t, "deployments")["app"]; img != "nginx:1.26" {
+		t.Fatalf("expected the template of revision 1, got image %s", img)
+	}
+	if managers := env.patchManagers("deployments"); len(managers) != 1 || managers[0] != fieldManager {
+		t.Fatalf("expected one patch by %s, got %v", fieldManager, managers)
+	}
+}
+
+func TestSetImage(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	// containers merge by name: the sidecar must survive the patch
+	dep, err := env.cs.AppsV1().Deployments(testNamespace).Get(context.TODO(), testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep.Spec.Template.Spec.Containers = append(dep.Spec.Template.Spec.Containers, corev1.Container{Name: "sidecar", Image: "envoy:1.30"})
+	dep.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox:1.36"}}
+	if _, err := env.cs.AppsV1().Deployments(testNamespace).Update(context.TODO(), dep, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range []string{"deployments", "statefulsets", "daemonsets", "cronjobs"} {
+		t.Run(kind, func(t *testing.T) {
+			w := env.do(http.MethodPost, "/api/k8s/"+kind+"/image?ns="+testNamespace+"&name="+testName+"&container=app&image=nginx:1.28", userOperator, url.Values{})
+			expectStatus(t, w, http.StatusOK)
+			if body := decodeJSON(t, w); body["previous"] != "nginx:1.27" {
+				t.Fatalf("expected previous image nginx:1.27, got %v", body["previous"])
+			}
+			if img := env.liveImages(t, kind)["app"]; img != "nginx:1.28" {
+				t.Fatalf("image not updated: %s", img)
+			}
+			w = env.do(http.MethodPost, "/api/k8s/"+kind+"/image?ns="+testNamespace+"&name="+testName+"&container=app&image=nginx:1.28", userOperator, url.Values{})
+			expectStatus(t, w, http.StatusOK)
+			if decodeJSON(t, w)["message"] != "unchanged" {
+				t.Fatalf("expected unchanged, got %s", w.Body.String())
+			}
+		})
+	}
+
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/deployments/image?ns="+testNamespace+"&name="+testName+"&container=init&image=busybox:1.37", userOperator, url.Values{}), http.StatusOK)
+	images := env.liveImages(t, "deployments")
+	if images["init"] != "busybox:1.37" || images["sidecar"] != "envoy:1.30" || images["app"] != "nginx:1.28" {
+		t.Fatalf("unexpected images after patches: %v", images)
+	}
+
+	base := "/api/k8s/deployments/image?ns=" + testNamespace + "&name=" + testName
+	expectStatus(t, env.do(http.MethodPost, base+"&container=missing&image=nginx:1.29", userOperator, url.Values{}), http.StatusNotFound)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/deployments/image?ns="+testNamespace+"&name=missing&container=app&image=nginx:1.29", userOperator, url.Values{}), http.StatusNotFound)
+	expectStatus(t, env.do(http.MethodPost, base+"&container=app", userOperator, url.Values{}), http.StatusBadRequest)
+	expectStatus(t, env.do(http.MethodPost, base+"&container=app&image=nginx%201.29", userOperator, url.Values{}), http.StatusBadRequest)
+	expectStatus(t, env.do(http.MethodPost, base+"&container=app&image=nginx:1.29", userViewer, url.Values{}), http.StatusForbidden)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/deployments/image?ns=other&name="+testName+"&container=app&image=nginx:1.29", userOperator, url.Values{}), http.StatusForbidden)
+}
+
+func TestAuditHashes(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	query := "?ns=" + testNamespace + "&name=" + testName
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/deployments/restart"+query, userOperator, url.Values{}), http.StatusOK)
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/deployments/pause"+query, userOperator, url.Values{}), http.StatusOK)
+	expectStatus(t, env.do(http.MethodDelete, "/api/k8s/configmaps"+query+"&confirm="+testName, userOperator, nil), http.StatusOK)
+
+	entries, _, err := models.ListAuditLogs(models.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[string][2]string{}
+	for _, e := range entries {
+		hashes[e.Kind+"/"+e.Action] = [2]string{e.BeforeHash, e.AfterHash}
+	}
+	for _, key := range []string{"Deployment/restart", "Deployment/pause"} {
+		h := hashes[key]
+		if h[0] == "" || h[1] == "" || h[0] == h[1] {
+			t.Fatalf("%s: expected distinct before and after hashes, got %v (entries %v)", key, h, hashes)
+		}
+	}
+	if h := hashes["ConfigMap/delete"]; h[0] == "" || h[1] != "" {
+		t.Fatalf("delete: expected only a before hash, got %v (entries %v)", h, hashes)
+	}
+}
+
+func TestSetImageProtectedNamespace(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	if _, err := models.CreateProtectedNamespace(models.AllNamespaces, testNamespace); err != nil {
+		t.Fatal(err)
+	}
+	w := env.do(http.MethodPost, "/api/k8s/deployments/image?ns="+testNamespace+"&name="+testName+"&container=app&image=nginx:1.28", userOperator, url.Values{})
+	expectStatus(t, w, http.StatusAccepted)
+	if img := env.liveImages(t, "deployments")["app"]; img != "nginx:1.27" {
+		t.Fatalf("image changed without approval: %s", img)
+	}
+	body := decodeJSON(t, w)
+	cr := body["changeRequest"].(map[string]interface{})
+	id := strconv.Itoa(int(cr["ID"].(float64)))
+	// the request carries the image patch, not the whole object
+	if cr["patchType"] != string(types.StrategicMergePatchType) || cr["force"] != false {
+		t.Fatalf("expected a strategic merge patch without force, got %v", cr)
+	}
+	if doc := cr["yaml"].(string); !strings.Contains(doc, "nginx:1.28") || strings.Contains(doc, "replicas") || strings.Contains(doc, "labels") {
+		t.Fatalf("expected only the image in the patch, got:\n%s", doc)
+	}
+	if diff := body["diff"].([]interface{}); len(diff) != 1 {
+		t.Fatalf("expected a single changed field, got %v", diff)
+	}
+
+	expectStatus(t, env.do(http.MethodPost, "/api/k8s/changes/"+id+"/approve", userAdmin, url.Values{}), http.StatusOK)
+	if img := env.liveImages(t, "deployments")["app"]; img != "nginx:1.28" {
+		t.Fatalf("approved image change was not applied: %s", img)
+	}
+}
+
+// registryStandIn serves the tags API of a registry holding repository team/app
+// behind a bearer token service that wants user/pass. Tags come in pages of two.
+func registryStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	tags := []string{"1.9", "1.10", "1.2", "latest", "1.10-debug"}
+	const token = "test-token"
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": token})
+		case r.Header.Get("Authorization") != "Bearer "+token:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="stand-in"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/team/app/tags/list":
+			start, _ := strconv.Atoi(r.URL.Query().Get("last"))
+			end := min(start+2, len(tags))
+			if end < len(tags) {
+				w.Header().Set("Link", `</v2/team/app/tags/list?n=2&last=`+strconv.Itoa(end)+`>; rel="next"`)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "team/app", "tags": tags[start:end]})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestImageTags(t *testing.T) {
+	env := newTestEnv(t)
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags?image=team/app", userViewer, nil), http.StatusServiceUnavailable)
+
+	srv := registryStandIn(t)
+	r, err := newRegistryClient(srv.URL, "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setRegistry(r)
+	t.Cleanup(func() { setRegistry(nil) })
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	w := env.do(http.MethodGet, "/api/k8s/registry/tags?image="+host+"/team/app:1.2", userViewer, nil)
+	expectStatus(t, w, http.StatusOK)
+	var body struct {
+		Repository string   `json:"repository"`
+		Tags       []string `json:"tags"`
+		Total      int      `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"latest", "1.10-debug", "1.10", "1.9", "1.2"}
+	if body.Repository != "team/app" || strings.Join(body.Tags, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v of team/app, got %s", want, w.Body.String())
+	}
+
+	w = env.do(http.MethodGet, "/api/k8s/registry/tags?image=team/app&q=1.1&limit=1", userViewer, nil)
+	expectStatus(t, w, http.StatusOK)
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Tags) != 1 || body.Tags[0] != "1.10-debug" || body.Total != 2 {
+		t.Fatalf("unexpected filtered tags %s", w.Body.String())
+	}
+
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags", userViewer, nil), http.StatusBadRequest)
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags?image=docker.io/team/app", userViewer, nil), http.StatusBadRequest)
+	for _, image := range []string{"team/../v2/_catalog", "team/app%3Fn=1", "team/app%23x", "team/app%252e", "Team/App", "team//app"} {
+		expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags?image="+image, userViewer, nil), http.StatusBadRequest)
+	}
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags?image=team/app&limit=0", userViewer, nil), http.StatusBadRequest)
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags?image=team/app", "", nil), http.StatusUnauthorized)
+
+	bad, err := newRegistryClient(srv.URL, "user", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setRegistry(bad)
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags?image=team/app", userViewer, nil), http.StatusBadGateway)
+}
+
+// A token service on another host gets the credentials only when it is configured
+// as a token host.
+func TestImageTagsForeignTokenRealm(t *testing.T) {
+	env := newTestEnv(t)
+	var sawCredentials atomic.Bool
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			sawCredentials.Store(true)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "anonymous"})
+	}))
+	t.Cleanup(tokenSrv.Close)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+tokenSrv.URL+`/token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "team/app", "tags": []string{"1.0"}})
+	}))
+	t.Cleanup(srv.Close)
+
+	r, err := newRegistryClient(srv.URL, "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setRegistry(r)
+	t.Cleanup(func() { setRegistry(nil) })
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags?image=team/app", userViewer, nil), http.StatusOK)
+	if sawCredentials.Load() {
+		t.Fatal("credentials sent to a token service on another host")
+	}
+
+	r, err = newRegistryClient(srv.URL, "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.tokenHosts = []string{strings.TrimPrefix(tokenSrv.URL, "http://")}
+	setRegistry(r)
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/registry/tags?image=team/app", userViewer, nil), http.StatusOK)
+	if !sawCredentials.Load() {
+		t.Fatal("credentials not sent to a configured token host")
+	}
+}
+
+// Neither the cached bearer token nor the password leave the registry: pagination
+// links to other origins end the listing, and other schemes are not trusted.
+func TestRegistryCredentialsStayWithRegistry(t *testing.T) {
+	var leaked atomic.Bool
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			leaked.Store(true)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"tags": []string{"foreign"}})
+	}))
+	t.Cleanup(foreign.Close)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<"+foreign.URL+`/v2/team/app/tags/list?last=1>; rel="next"`)
+		json.NewEncoder(w).Encode(map[string]interface{}{"tags": []string{"1.0"}})
+	}))
+	t.Cleanup(srv.Close)
+
+	r, err := newRegistryClient(srv.URL, "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.tokens["repository:team/app:pull"] = "secret-token"
+	tags, err := r.tags("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tags, ",") != "1.0" {
+		t.Fatalf("expected the listing to stop at the foreign link, got %v", tags)
+	}
+	resp, err := r.do(foreign.URL+"/v2/team/app/tags/list", "repository:team/app:pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if leaked.Load() {
+		t.Fatal("credentials sent to another host")
+	}
+
+	https, err := newRegistryClient("https://registry.example.com", "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]bool{
+		"https://registry.example.com/token": true,
+		"http://registry.example.com/token":  false,
+		"https://auth.example.com/token":     false,
+	} {
+		u, _ := url.Parse(target)
+		if got := https.trusted(u); got != want {
+			t.Fatalf("trusted(%s) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+// testKubeconfig returns a kubeconfig for an unreachable cluster whose user entry is user.
+func testKubeconfig(user string) string {
+	return `apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: remote
+  context:
+    cluster: remote
+    user: remote
+current-context: remote
+users:
+- name: remote
+  user:
+` + user
+}
+
+// addCluster posts a kubeconfig to the cluster admin API as the admin.
+func (e *testEnv) addCluster(name, kubeconfig string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"name": name, "kubeconfig": kubeconfig})
+	req := httptest.NewRequest(http.MethodPost, "/api/k8s/clusters", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", userAdmin)
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAddClusterRejectsUnsafeKubeconfig(t *testing.T) {
+	env := newTestEnv(t)
+	for name, user := range map[string]string{
+		"exec": `    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: /bin/sh
+      args: ["-c", "touch /tmp/pwned"]
+`,
+		"authprovider": `    auth-provider:
+      name: oidc
+      config:
+        cmd-path: /bin/sh
+`,
+		"tokenfile":  "    tokenFile: /etc/shadow\n",
+		"clientcert": "    client-certificate: /etc/ssl/private/server.crt\n    client-key: /etc/ssl/private/server.key\n",
+	} {
+		w := env.addCluster(name, testKubeconfig(user))
+		expectStatus(t, w, http.StatusBadRequest)
+		if _, ok := getCluster(name); ok {
+			t.Fatalf("%s: cluster registered from an unsafe kubeconfig", name)
+		}
+	}
+	expectStatus(t, env.addCluster("inline", testKubeconfig("    token: abc\n")), http.StatusCreated)
+	t.Cleanup(func() {
+		unregisterCluster("inline")
+	})
+}
+
+// A kubeconfig context named like a cluster added through the API does not replace
+// it, so the cluster can still be removed.
+func TestClusterNameCollision(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	expectStatus(t, env.addCluster("dup", testKubeconfig("    token: abc\n")), http.StatusCreated)
+	t.Cleanup(func() { unregisterCluster("dup") })
+	shadow := newClusterFromClients("dup", fake.NewClientset(), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))
+	shadow.source = clusterSourceFile
+	if registerCluster(shadow, true) {
+		t.Fatal("a second cluster registered under a taken name")
+	}
+	if cl, _ := getCluster("dup"); cl.source != clusterSourceDB {
+		t.Fatalf("the first registration was replaced by one from %s", cl.source)
+	}
+	clustersMu.RLock()
+	def := defaultCluster
+	clustersMu.RUnlock()
+	if def != testCluster {
+		t.Fatalf("a rejected registration changed the default cluster to %s", def)
+	}
+	expectStatus(t, env.do(http.MethodDelete, "/api/k8s/clusters/dup", userAdmin, nil), http.StatusOK)
+}
+
+func TestRemoveDefaultCluster(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	expectStatus(t, env.addCluster("extra", testKubeconfig("    token: abc\n")), http.StatusCreated)
+	clustersMu.Lock()
+	defaultCluster = "extra"
+	clustersMu.Unlock()
+
+	expectStatus(t, env.do(http.MethodDelete, "/api/k8s/clusters/extra", userAdmin, nil), http.StatusOK)
+	clustersMu.RLock()
+	def := defaultCluster
+	clustersMu.RUnlock()
+	if def != testCluster {
+		t.Fatalf("expected default cluster %s after removing the default, got %q", testCluster, def)
+	}
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/deployments?ns="+testNamespace, userOperator, nil), http.StatusOK)
+}
+
+func TestGrantsPerCluster(t *testing.T) {
+	env := newTestEnv(t, testObjectList()...)
+	registerCluster(newClusterFromClients("other", fake.NewClientset(testObjectList()...), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())), false)
+	t.Cleanup(func() {
+		unregisterCluster("other")
+	})
+	if _, err := models.CreateNamespaceGrant(models.SubjectUser, userViewer, testCluster, testNamespace, []string{models.VerbList, models.VerbGet}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/deployments?ns="+testNamespace, userViewer, nil), http.StatusOK)
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/deployments?ns="+testNamespace+"&cluster="+testCluster, userViewer, nil), http.StatusOK)
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/deployments?ns="+testNamespace+"&cluster=other", userViewer, nil), http.StatusForbidden)
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/deployments/yaml?ns="+testNamespace+"&name="+testName+"&cluster=other", userViewer, nil), http.StatusForbidden)
+	// the operator's grant names every cluster
+	expectStatus(t, env.do(http.MethodGet, "/api/k8s/deployments?ns="+testNamespace+"&cluster=other", userOperator, nil), http.StatusOK)
+}
@@ -0,0 +1,200 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"gin-demo/models"
+)
+
+// annotations maintained by the deployment controller and kubectl
+const (
+	revisionAnnotation    = "deployment.kubernetes.io/revision"
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+)
+
+// deploymentRevision is one entry of a deployment rollout history.
+type deploymentRevision struct {
+	Revision    int64     `json:"revision"`
+	ReplicaSet  string    `json:"replicaSet"`
+	ChangeCause string    `json:"changeCause"`
+	Images      []string  `json:"images"`
+	Replicas    int32     `json:"replicas"`
+	Current     bool      `json:"current"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// ownedReplicaSets returns the replicasets controlled by d, newest revision first.
+func ownedReplicaSets(ctx context.Context, cs kubernetes.Interface, d *appsv1.Deployment) ([]appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	rsList, err := cs.AppsV1().ReplicaSets(d.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var owned []appsv1.ReplicaSet
+	for _, rs := range rsList.Items {
+		if metav1.IsControlledBy(&rs, d) {
+			owned = append(owned, rs)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return replicaSetRevision(&owned[i]) > replicaSetRevision(&owned[j]) })
+	return owned, nil
+}
+
+func replicaSetRevision(rs *appsv1.ReplicaSet) int64 {
+	rev, _ := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+	return rev
+}
+
+// GetDeploymentHistory lists the revisions of a deployment like kubectl rollout history
+func GetDeploymentHistory(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbGet)
+	if !ok {
+		return
+	}
+
+	ctx := context.TODO()
+	dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	owned, err := ownedReplicaSets(ctx, cs, dep)
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	current := dep.Annotations[revisionAnnotation]
+	revisions := make([]deploymentRevision, 0, len(owned))
+	for i := range owned {
+		rs := &owned[i]
+		var images []string
+		for _, ctr := range rs.Spec.Template.Spec.Containers {
+			images = append(images, ctr.Image)
+		}
+		revisions = append(revisions, deploymentRevision{
+			Revision:    replicaSetRevision(rs),
+			ReplicaSet:  rs.Name,
+			ChangeCause: rs.Annotations[changeCauseAnnotation],
+			Images:      images,
+			Replicas:    rs.Status.Replicas,
+			Current:     rs.Annotations[revisionAnnotation] == current,
+			CreatedAt:   rs.CreationTimestamp.Time,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+// RollbackDeployment restores the pod template of a revision like kubectl rollout undo.
+// revision=0 or omitted selects the previous revision. In a protected namespace the
+// rollback patch is filed as a change request.
+func RollbackDeployment(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbUpdate)
+	if !ok {
+		return
+	}
+	var toRevision int64
+	if v := c.Query("revision"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid revision"})
+			return
+		}
+		toRevision = n
+	}
+
+	ctx := context.TODO()
+	dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if dep.Spec.Paused {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot rollback a paused deployment; resume it first"})
+		return
+	}
+	owned, err := ownedReplicaSets(ctx, cs, dep)
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	var target *appsv1.ReplicaSet
+	if toRevision == 0 {
+		// owned is sorted newest first: the previous revision is the second entry
+		if len(owned) < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no previous revision to roll back to"})
+			return
+		}
+		target = &owned[1]
+	} else {
+		for i := range owned {
+			if replicaSetRevision(&owned[i]) == toRevision {
+				target = &owned[i]
+				break
+			}
+		}
+		if target == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+			return
+		}
+	}
+	if target.Annotations[revisionAnnotation] == dep.Annotations[revisionAnnotation] {
+		c.JSON(http.StatusOK, gin.H{"message": "skipped rollback: already at the requested revision", "revision": replicaSetRevision(target)})
+		return
+	}
+
+	template := target.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	ops := []map[string]interface{}{
+		// fail instead of overwriting a concurrent change
+		{"op": "test", "path": "/metadata/resourceVersion", "value": dep.ResourceVersion},
+		{"op": "replace", "path": "/spec/template", "value": template},
+	}
+	if cause, ok := target.Annotations[changeCauseAnnotation]; ok {
+		annotations := map[string]string{}
+		for k, v := range dep.Annotations {
+			annotations[k] = v
+		}
+		annotations[changeCauseAnnotation] = cause
+		ops = append(ops, map[string]interface{}{"op": "add", "path": "/metadata/annotations", "value": annotations})
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cl, protected, ok := protectedNamespace(c, namespace)
+	if !ok {
+		return
+	}
+	if protected {
+		requestPatchChange(c, cl, "deployments", dep, types.JSONPatchType, patch)
+		return
+	}
+	rec := auditFor(c)
+	rec.before = objectHash(dep)
+	patched, err := cs.AppsV1().Deployments(namespace).Patch(ctx, name, types.JSONPatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	rec.after = objectHash(patched)
+
+	c.JSON(http.StatusOK, gin.H{"message": "rolled back", "revision": replicaSetRevision(target)})
+}
@@ -0,0 +1,137 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"gin-demo/models"
+)
+
+// podSpecOf returns the pod template spec of a workload and its path in the object.
+func podSpecOf(obj runtime.Object) (*corev1.PodSpec, []string) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &o.Spec.Template.Spec, []string{"spec", "template", "spec"}
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.Spec, []string{"spec", "template", "spec"}
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template.Spec, []string{"spec", "template", "spec"}
+	case *batchv1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template.Spec, []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	}
+	return nil, nil
+}
+
+// findContainer returns the container or init container called name and the field
+// of spec holding it.
+func findContainer(spec *corev1.PodSpec, name string) (*corev1.Container, string) {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return &spec.Containers[i], "containers"
+		}
+	}
+	for i := range spec.InitContainers {
+		if spec.InitContainers[i].Name == name {
+			return &spec.InitContainers[i], "initContainers"
+		}
+	}
+	return nil, ""
+}
+
+// imagePatch builds a strategic merge patch setting the image of one container;
+// containers merge by name, so the others are left alone.
+func imagePatch(path []string, field, container, image string) ([]byte, error) {
+	var patch interface{} = map[string]interface{}{
+		field: []map[string]string{{"name": container, "image": image}},
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		patch = map[string]interface{}{path[i]: patch}
+	}
+	return json.Marshal(patch)
+}
+
+// setImage sets the image of a container of a deployment, statefulset, daemonset or
+// cronjob with a strategic merge patch. In a protected namespace the patch is filed
+// as a change request instead.
+func setImage(c *gin.Context, kind string) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbUpdate)
+	if !ok {
+		return
+	}
+	container := c.Query("container")
+	image := strings.TrimSpace(c.Query("image"))
+	if container == "" || image == "" || strings.ContainsAny(image, " \t\n") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "container and a valid image required"})
+		return
+	}
+
+	kc := typedKinds[kind].client(cs, namespace)
+	ctx := context.TODO()
+	live, err := kc.get(ctx, name)
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	spec, path := podSpecOf(live)
+	target, field := findContainer(spec, container)
+	if target == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("container %q not found in %s/%s", container, namespace, name)})
+		return
+	}
+	previous := target.Image
+	if previous == image {
+		skipAudit(c)
+		c.JSON(http.StatusOK, gin.H{"message": "unchanged", "container": container, "image": image})
+		return
+	}
+	patch, err := imagePatch(path, field, container, image)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cl, protected, ok := protectedNamespace(c, namespace)
+	if !ok {
+		return
+	}
+	if protected {
+		requestPatchChange(c, cl, kind, live, types.StrategicMergePatchType, patch)
+		return
+	}
+
+	rec := auditFor(c)
+	rec.before = objectHash(live)
+	rec.detail = fmt.Sprintf("container=%s image=%s previous=%s", container, image, previous)
+	patched, err := kc.patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	rec.after = objectHash(patched)
+	logrus.Infof("k8s: %s set image of %s/%s container %s to %s", currentUser(c), namespace, name, container, image)
+	c.JSON(http.StatusOK, gin.H{"message": "updated", "container": container, "image": image, "previous": previous})
+}
+
+// SetDeploymentImage sets the image of a deployment container
+func SetDeploymentImage(c *gin.Context) { setImage(c, "deployments") }
+
+// SetStatefulSetImage sets the image of a statefulset container
+func SetStatefulSetImage(c *gin.Context) { setImage(c, "statefulsets") }
+
+// SetDaemonSetImage sets the image of a daemonset container
+func SetDaemonSetImage(c *gin.Context) { setImage(c, "daemonsets") }
+
+// SetCronJobImage sets the image of a cronjob container
+func SetCronJobImage(c *gin.Context) { setImage(c, "cronjobs") }
@@ -0,0 +1,301 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+
+	"gin-demo/models"
+)
+
+// instantiateAnnotation marks jobs created by hand from a cronjob, as kubectl does.
+const instantiateAnnotation = "cronjob.kubernetes.io/instantiate"
+
+// jobNameMaxLength keeps job names usable as the job-name label value.
+const jobNameMaxLength = 63
+
+// labels the job controller adds to the selector and pod template of each job
+var jobControllerLabels = []string{
+	batchv1.ControllerUidLabel,
+	batchv1.JobNameLabel,
+	"controller-uid",
+	"job-name",
+}
+
+// job cleanup status filters
+const (
+	cleanupFinished  = "finished"
+	cleanupCompleted = "completed"
+	cleanupFailed    = "failed"
+)
+
+// generatedJobName returns base-infix-xxxxx, shortening base to fit jobNameMaxLength.
+func generatedJobName(base, infix string) string {
+	suffix := "-" + infix + "-" + utilrand.String(5)
+	if len(base)+len(suffix) > jobNameMaxLength {
+		base = base[:jobNameMaxLength-len(suffix)]
+	}
+	return base + suffix
+}
+
+// jobFinished reports whether a job has completed or failed, and when.
+func jobFinished(j *batchv1.Job) (finished bool, condition batchv1.JobConditionType, at time.Time) {
+	for _, cond := range j.Status.Conditions {
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) && cond.Status == corev1.ConditionTrue {
+			at = cond.LastTransitionTime.Time
+			if j.Status.CompletionTime != nil {
+				at = j.Status.CompletionTime.Time
+			}
+			return true, cond.Type, at
+		}
+	}
+	return false, "", time.Time{}
+}
+
+// RunCronJob creates a job from the jobTemplate of a cronjob, like
+// kubectl create job --from=cronjob/<name>. The job is owned by the cronjob.
+func RunCronJob(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbCreate)
+	if !ok {
+		return
+	}
+	ctx := context.TODO()
+	cj, err := cs.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	annotations := map[string]string{instantiateAnnotation: "manual"}
+	for k, v := range cj.Spec.JobTemplate.Annotations {
+		annotations[k] = v
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        generatedJobName(cj.Name, "manual"),
+			Namespace:   namespace,
+			Labels:      cj.Spec.JobTemplate.Labels,
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(cj, batchv1.SchemeGroupVersion.WithKind("CronJob")),
+			},
+		},
+		Spec: cj.Spec.JobTemplate.Spec,
+	}
+	created, err := cs.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{FieldManager: fieldManager})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	rec := auditFor(c)
+	rec.detail = "job=" + created.Name
+	rec.after = objectHash(created)
+	logrus.Infof("k8s: job %s/%s created from cronjob %s by %s", namespace, created.Name, name, currentUser(c))
+	c.JSON(http.StatusCreated, gin.H{"message": "created", "namespace": namespace, "name": created.Name})
+}
+
+// setCronJobSuspended suspends or resumes a cronjob.
+func setCronJobSuspended(c *gin.Context, suspend bool) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbUpdate)
+	if !ok {
+		return
+	}
+	patch := fmt.Sprintf(`{"spec":{"suspend":%t}}`, suspend)
+	if !patchWorkload(c, cs, "cronjobs", namespace, name, types.StrategicMergePatchType, []byte(patch)) {
+		return
+	}
+	msg := "resumed"
+	if suspend {
+		msg = "suspended"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg})
+}
+
+// SuspendCronJob stops a cronjob from scheduling new jobs
+func SuspendCronJob(c *gin.Context) { setCronJobSuspended(c, true) }
+
+// ResumeCronJob lets a suspended cronjob schedule jobs again
+func ResumeCronJob(c *gin.Context) { setCronJobSuspended(c, false) }
+
+// RerunJob clones a finished job under a new name. The selector and the labels the
+// job controller generated are dropped so that the clone gets its own.
+func RerunJob(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbCreate)
+	if !ok {
+		return
+	}
+	ctx := context.TODO()
+	job, err := cs.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if finished, _, _ := jobFinished(job); !finished {
+		c.JSON(http.StatusConflict, gin.H{"error": "job has not finished"})
+		return
+	}
+
+	spec := *job.Spec.DeepCopy()
+	template := &spec.Template
+	for _, l := range jobControllerLabels {
+		delete(template.Labels, l)
+	}
+	if spec.ManualSelector == nil || !*spec.ManualSelector {
+		spec.Selector = nil
+	}
+	labels := map[string]string{}
+	for k, v := range job.Labels {
+		labels[k] = v
+	}
+	for _, l := range jobControllerLabels {
+		delete(labels, l)
+	}
+	clone := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        generatedJobName(job.Name, "rerun"),
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: job.Annotations,
+		},
+		Spec: spec,
+	}
+	delete(clone.Annotations, lastAppliedAnnotation)
+	created, err := cs.BatchV1().Jobs(namespace).Create(ctx, clone, metav1.CreateOptions{FieldManager: fieldManager})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	rec := auditFor(c)
+	rec.detail = "job=" + created.Name
+	rec.after = objectHash(created)
+	logrus.Infof("k8s: job %s/%s re-run as %s by %s", namespace, name, created.Name, currentUser(c))
+	c.JSON(http.StatusCreated, gin.H{"message": "created", "namespace": namespace, "name": created.Name})
+}
+
+// CleanupJobs deletes the finished jobs of namespace ns whose completion is older than
+// ttlSeconds (default 0: every finished job). status selects completed, failed or
+// finished (both, default) jobs; dryRun=true only lists them. Pods are deleted in
+// the background. Protected namespaces are refused (409) except for dry runs.
+func CleanupJobs(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	status := c.DefaultQuery("status", cleanupFinished)
+	if status != cleanupFinished && status != cleanupCompleted && status != cleanupFailed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be finished, completed or failed"})
+		return
+	}
+	ttl := 0
+	if v := c.Query("ttlSeconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttlSeconds must be a non-negative integer"})
+			return
+		}
+		ttl = n
+	}
+	dryRun := c.Query("dryRun") == "true"
+	if dryRun {
+		skipAudit(c)
+	}
+	if !authorizeNamespace(c, namespace, models.VerbDelete) {
+		return
+	}
+	if !dryRun && !requireUnprotected(c, namespace) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.TODO()
+	jobs, err := cs.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(ttl) * time.Second)
+	policy := metav1.DeletePropagationBackground
+	deleted := []string{}
+	var deletedJobs []*batchv1.Job
+	failed := map[string]string{}
+	for i := range jobs.Items {
+		j := &jobs.Items[i]
+		finished, cond, at := jobFinished(j)
+		if !finished || at.After(cutoff) {
+			continue
+		}
+		if (status == cleanupCompleted && cond != batchv1.JobComplete) || (status == cleanupFailed && cond != batchv1.JobFailed) {
+			continue
+		}
+		if !dryRun {
+			err := cs.BatchV1().Jobs(namespace).Delete(ctx, j.Name, metav1.DeleteOptions{PropagationPolicy: &policy})
+			if err != nil {
+				failed[j.Name] = err.Error()
+				continue
+			}
+		}
+		deleted = append(deleted, j.Name)
+		deletedJobs = append(deletedJobs, j)
+	}
+	if !dryRun {
+		rec := auditFor(c)
+		rec.detail = "deleted=" + strings.Join(deleted, ",")
+		// the deleted jobs as they were; nothing is left after
+		rec.before = objectHash(deletedJobs)
+		logrus.Infof("k8s: %d finished jobs deleted in %s by %s", len(deleted), namespace, currentUser(c))
+	}
+
+	res := gin.H{"deleted": deleted, "dryRun": dryRun}
+	if len(failed) > 0 {
+		res["errors"] = failed
+		c.JSON(http.StatusMultiStatus, res)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// SetCronJobTTL sets ttlSecondsAfterFinished in the jobTemplate of a cronjob so the
+// cluster deletes its jobs that long after they finish; an empty seconds removes it.
+func SetCronJobTTL(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbUpdate)
+	if !ok {
+		return
+	}
+	var ttl *int32
+	if v := c.Query("seconds"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seconds must be a non-negative integer"})
+			return
+		}
+		n32 := int32(n)
+		ttl = &n32
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"jobTemplate": map[string]interface{}{"spec": map[string]interface{}{"ttlSecondsAfterFinished": ttl}}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !patchWorkload(c, cs, "cronjobs", namespace, name, types.StrategicMergePatchType, patch) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "updated", "ttlSecondsAfterFinished": ttl})
+}
@@ -3,134 +3,220 @@ package kubernetes
 import (
 	"context"
 	"net/http"
-	"path/filepath"
 
 	"github.com/gin-gonic/gin"
-	"gopkg.in/yaml.v2"
-	appsv1 "k8s.io/api/apps/v1"
-	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-)
+	"k8s.io/apimachinery/pkg/labels"
 
-var clientset *kubernetes.Clientset
+	"gin-demo/models"
+)
 
-func init() {
-	// Load kubeconfig
-	kubeconfig := filepath.Join("conf", "kubernetes.config")
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		panic(err.Error())
+// GetNamespaces returns list of namespaces the current user has been granted
+func GetNamespaces(c *gin.Context) {
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
 	}
 
-	// Create clientset
-	clientset, err = kubernetes.NewForConfig(config)
+	namespaces, err := cl.client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
-		panic(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-}
 
-// GetNamespaces returns list of namespaces
-func GetNamespaces(c *gin.Context) {
-	namespaces, err := clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	grants, err := models.UserNamespaceGrants(currentUser(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var nsList []string
+	nsList := []string{}
 	for _, ns := range namespaces.Items {
-		nsList = append(nsList, ns.Name)
+		for _, g := range grants {
+			if g.Allows(cl.name, ns.Name, models.VerbList) {
+				nsList = append(nsList, ns.Name)
+				break
+			}
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"namespaces": nsList})
 }
 
-// GetDeployments returns deployments for a namespace
+// GetDeployments returns deployments for a namespace, from the informer cache unless fresh=true
 func GetDeployments(c *gin.Context) {
 	namespace := c.Query("ns")
 	if namespace == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
 		return
 	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
 
-	deployments, err := clientset.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	if rc := cacheFor(c, cl); rc != nil {
+		items, err := rc.deployments.Deployments(namespace).List(labels.Everything())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deployments": sortByName(items), "source": sourceCache})
+		return
+	}
+
+	deployments, err := cl.client.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"deployments": deployments.Items})
+	c.JSON(http.StatusOK, gin.H{"deployments": deployments.Items, "source": sourceLive})
 }
 
-// GetDaemonSets returns daemonsets for a namespace
+// GetDaemonSets returns daemonsets for a namespace, from the informer cache unless fresh=true
 func GetDaemonSets(c *gin.Context) {
 	namespace := c.Query("ns")
 	if namespace == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
 		return
 	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
 
-	daemonsets, err := clientset.AppsV1().DaemonSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	if rc := cacheFor(c, cl); rc != nil {
+		items, err := rc.daemonsets.DaemonSets(namespace).List(labels.Everything())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"daemonsets": sortByName(items), "source": sourceCache})
+		return
+	}
+
+	daemonsets, err := cl.client.AppsV1().DaemonSets(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"daemonsets": daemonsets.Items})
+	c.JSON(http.StatusOK, gin.H{"daemonsets": daemonsets.Items, "source": sourceLive})
 }
 
-// GetStatefulSets returns statefulsets for a namespace
+// GetStatefulSets returns statefulsets for a namespace, from the informer cache unless fresh=true
 func GetStatefulSets(c *gin.Context) {
 	namespace := c.Query("ns")
 	if namespace == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
 		return
 	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	if rc := cacheFor(c, cl); rc != nil {
+		items, err := rc.statefulsets.StatefulSets(namespace).List(labels.Everything())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"statefulsets": sortByName(items), "source": sourceCache})
+		return
+	}
 
-	statefulsets, err := clientset.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	statefulsets, err := cl.client.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"statefulsets": statefulsets.Items})
+	c.JSON(http.StatusOK, gin.H{"statefulsets": statefulsets.Items, "source": sourceLive})
 }
 
-// GetJobs returns jobs for a namespace
+// GetJobs returns jobs for a namespace, from the informer cache unless fresh=true
 func GetJobs(c *gin.Context) {
 	namespace := c.Query("ns")
 	if namespace == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
 		return
 	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	if rc := cacheFor(c, cl); rc != nil {
+		items, err := rc.jobs.Jobs(namespace).List(labels.Everything())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"jobs": sortByName(items), "source": sourceCache})
+		return
+	}
 
-	jobs, err := clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{})
+	jobs, err := cl.client.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"jobs": jobs.Items})
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs.Items, "source": sourceLive})
 }
 
-// GetCronJobs returns cronjobs for a namespace
+// GetCronJobs returns cronjobs for a namespace, from the informer cache unless fresh=true
 func GetCronJobs(c *gin.Context) {
 	namespace := c.Query("ns")
 	if namespace == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
 		return
 	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
 
-	cronjobs, err := clientset.BatchV1().CronJobs(namespace).List(context.TODO(), metav1.ListOptions{})
+	if rc := cacheFor(c, cl); rc != nil {
+		items, err := rc.cronjobs.CronJobs(namespace).List(labels.Everything())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"cronjobs": sortByName(items), "source": sourceCache})
+		return
+	}
+
+	cronjobs, err := cl.client.BatchV1().CronJobs(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"cronjobs": cronjobs.Items})
+	c.JSON(http.StatusOK, gin.H{"cronjobs": cronjobs.Items, "source": sourceLive})
 }
 
 // GetPodsForDeployment returns pods controlled by a deployment
@@ -141,31 +227,22 @@ func GetPodsForDeployment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
-
-	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizeNamespace(c, namespace, models.VerbList) {
 		return
 	}
 
-	selector := deployment.Spec.Selector.MatchLabels
-	labelSelector := ""
-	for k, v := range selector {
-		if labelSelector != "" {
-			labelSelector += ","
-		}
-		labelSelector += k + "=" + v
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
 	}
 
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
+	deployment, err := cl.client.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"pods": pods.Items})
+	listPodsForSelector(c, cl, namespace, "Deployment", name, deployment.Spec.Selector)
 }
 
 // GetPodsForDaemonSet returns pods controlled by a daemonset
@@ -176,31 +253,22 @@ func GetPodsForDaemonSet(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
-
-	ds, err := clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizeNamespace(c, namespace, models.VerbList) {
 		return
 	}
 
-	selector := ds.Spec.Selector.MatchLabels
-	labelSelector := ""
-	for k, v := range selector {
-		if labelSelector != "" {
-			labelSelector += ","
-		}
-		labelSelector += k + "=" + v
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
 	}
 
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
+	ds, err := cl.client.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"pods": pods.Items})
+	listPodsForSelector(c, cl, namespace, "DaemonSet", name, ds.Spec.Selector)
 }
 
 // GetPodsForStatefulSet returns pods controlled by a statefulset
@@ -211,48 +279,103 @@ func GetPodsForStatefulSet(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
 
-	sts, err := clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	sts, err := cl.client.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	selector := sts.Spec.Selector.MatchLabels
-	labelSelector := ""
-	for k, v := range selector {
-		if labelSelector != "" {
-			labelSelector += ","
-		}
-		labelSelector += k + "=" + v
-	}
+	listPodsForSelector(c, cl, namespace, "StatefulSet", name, sts.Spec.Selector)
+}
 
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
+// listPodsForSelector writes the pods of namespace matched by a workload selector,
+// together with the recent events of the workload and those pods
+func listPodsForSelector(c *gin.Context, cl *cluster, namespace, kind, name string, ls *metav1.LabelSelector) {
+	selector, err := metav1.LabelSelectorAsSelector(ls)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"pods": pods.Items})
+	var res gin.H
+	var pods []*corev1.Pod
+	if rc := cacheFor(c, cl); rc != nil {
+		items, err := rc.pods.Pods(namespace).List(selector)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		pods = sortByName(items)
+		res = gin.H{"pods": pods, "source": sourceCache}
+	} else {
+		list, err := cl.client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: selector.String(),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		pods = pointers(list.Items)
+		res = gin.H{"pods": list.Items, "source": sourceLive}
+	}
+	podNames := make([]string, 0, len(pods))
+	for _, p := range pods {
+		podNames = append(podNames, p.Name)
+	}
+	addPodMetrics(context.TODO(), res, cl, namespace, selector.String(), pods)
+
+	events, err := workloadEvents(context.TODO(), cl.client, namespace, kind, name, podNames)
+	if err != nil {
+		res["events"] = []eventView{}
+		res["eventsError"] = err.Error()
+	} else {
+		res["events"] = events
+	}
+	c.JSON(http.StatusOK, res)
 }
 
-// GetServices returns services for a namespace
+// GetServices returns services for a namespace, from the informer cache unless fresh=true
 func GetServices(c *gin.Context) {
 	namespace := c.Query("ns")
 	if namespace == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
 		return
 	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
 
-	services, err := clientset.CoreV1().Services(namespace).List(context.TODO(), metav1.ListOptions{})
+	if rc := cacheFor(c, cl); rc != nil {
+		items, err := rc.services.Services(namespace).List(labels.Everything())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"services": sortByName(items), "source": sourceCache})
+		return
+	}
+
+	services, err := cl.client.CoreV1().Services(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"services": services.Items})
+	c.JSON(http.StatusOK, gin.H{"services": services.Items, "source": sourceLive})
 }
 
 // GetDeploymentYAML returns YAML of a deployment
@@ -263,47 +386,32 @@ func GetDeploymentYAML(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
-
-	dep, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	yamlData, err := yaml.Marshal(dep)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizeNamespace(c, namespace, models.VerbGet) {
 		return
 	}
 
-	c.Header("Content-Type", "application/yaml")
-	c.String(http.StatusOK, string(yamlData))
-}
-
-// UpdateDeployment updates a deployment from YAML
-func UpdateDeployment(c *gin.Context) {
-	namespace := c.Query("ns")
-	name := c.Query("name")
-	yamlStr := c.PostForm("yaml")
-	if namespace == "" || name == "" || yamlStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace, name and yaml required"})
+	cs, ok := clientFor(c)
+	if !ok {
 		return
 	}
 
-	var dep appsv1.Deployment
-	err := yaml.Unmarshal([]byte(yamlStr), &dep)
+	dep, err := cs.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = clientset.AppsV1().Deployments(namespace).Update(context.TODO(), &dep, metav1.UpdateOptions{})
+	obj, err := toObjectMap(dep, "deployments")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	writeObject(c, obj)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+// UpdateDeployment previews or applies YAML changes to a deployment, see applyYAML
+func UpdateDeployment(c *gin.Context) {
+	applyYAML(c, "deployments")
 }
 
 // GetDaemonSetYAML returns YAML of a daemonset
@@ -314,47 +422,32 @@ func GetDaemonSetYAML(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
-
-	ds, err := clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizeNamespace(c, namespace, models.VerbGet) {
 		return
 	}
 
-	yamlData, err := yaml.Marshal(ds)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	cs, ok := clientFor(c)
+	if !ok {
 		return
 	}
 
-	c.Header("Content-Type", "application/yaml")
-	c.String(http.StatusOK, string(yamlData))
-}
-
-// UpdateDaemonSet updates a daemonset from YAML
-func UpdateDaemonSet(c *gin.Context) {
-	namespace := c.Query("ns")
-	name := c.Query("name")
-	yamlStr := c.PostForm("yaml")
-	if namespace == "" || name == "" || yamlStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace, name and yaml required"})
-		return
-	}
-
-	var ds appsv1.DaemonSet
-	err := yaml.Unmarshal([]byte(yamlStr), &ds)
+	ds, err := cs.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = clientset.AppsV1().DaemonSets(namespace).Update(context.TODO(), &ds, metav1.UpdateOptions{})
+	obj, err := toObjectMap(ds, "daemonsets")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	writeObject(c, obj)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+// UpdateDaemonSet previews or applies YAML changes to a daemonset, see applyYAML
+func UpdateDaemonSet(c *gin.Context) {
+	applyYAML(c, "daemonsets")
 }
 
 // GetStatefulSetYAML returns YAML of a statefulset
@@ -365,47 +458,32 @@ func GetStatefulSetYAML(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
-
-	sts, err := clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	yamlData, err := yaml.Marshal(sts)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizeNamespace(c, namespace, models.VerbGet) {
 		return
 	}
 
-	c.Header("Content-Type", "application/yaml")
-	c.String(http.StatusOK, string(yamlData))
-}
-
-// UpdateStatefulSet updates a statefulset from YAML
-func UpdateStatefulSet(c *gin.Context) {
-	namespace := c.Query("ns")
-	name := c.Query("name")
-	yamlStr := c.PostForm("yaml")
-	if namespace == "" || name == "" || yamlStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace, name and yaml required"})
+	cs, ok := clientFor(c)
+	if !ok {
 		return
 	}
 
-	var sts appsv1.StatefulSet
-	err := yaml.Unmarshal([]byte(yamlStr), &sts)
+	sts, err := cs.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = clientset.AppsV1().StatefulSets(namespace).Update(context.TODO(), &sts, metav1.UpdateOptions{})
+	obj, err := toObjectMap(sts, "statefulsets")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	writeObject(c, obj)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+// UpdateStatefulSet previews or applies YAML changes to a statefulset, see applyYAML
+func UpdateStatefulSet(c *gin.Context) {
+	applyYAML(c, "statefulsets")
 }
 
 // GetJobYAML returns YAML of a job
@@ -416,47 +494,32 @@ func GetJobYAML(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
-
-	job, err := clientset.BatchV1().Jobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizeNamespace(c, namespace, models.VerbGet) {
 		return
 	}
 
-	yamlData, err := yaml.Marshal(job)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.Header("Content-Type", "application/yaml")
-	c.String(http.StatusOK, string(yamlData))
-}
-
-// UpdateJob updates a job from YAML
-func UpdateJob(c *gin.Context) {
-	namespace := c.Query("ns")
-	name := c.Query("name")
-	yamlStr := c.PostForm("yaml")
-	if namespace == "" || name == "" || yamlStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace, name and yaml required"})
+	cs, ok := clientFor(c)
+	if !ok {
 		return
 	}
 
-	var job batchv1.Job
-	err := yaml.Unmarshal([]byte(yamlStr), &job)
+	job, err := cs.BatchV1().Jobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = clientset.BatchV1().Jobs(namespace).Update(context.TODO(), &job, metav1.UpdateOptions{})
+	obj, err := toObjectMap(job, "jobs")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	writeObject(c, obj)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+// UpdateJob previews or applies YAML changes to a job, see applyYAML
+func UpdateJob(c *gin.Context) {
+	applyYAML(c, "jobs")
 }
 
 // GetCronJobYAML returns YAML of a cronjob
@@ -467,47 +530,32 @@ func GetCronJobYAML(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
-
-	cj, err := clientset.BatchV1().CronJobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	yamlData, err := yaml.Marshal(cj)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizeNamespace(c, namespace, models.VerbGet) {
 		return
 	}
 
-	c.Header("Content-Type", "application/yaml")
-	c.String(http.StatusOK, string(yamlData))
-}
-
-// UpdateCronJob updates a cronjob from YAML
-func UpdateCronJob(c *gin.Context) {
-	namespace := c.Query("ns")
-	name := c.Query("name")
-	yamlStr := c.PostForm("yaml")
-	if namespace == "" || name == "" || yamlStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace, name and yaml required"})
+	cs, ok := clientFor(c)
+	if !ok {
 		return
 	}
 
-	var cj batchv1.CronJob
-	err := yaml.Unmarshal([]byte(yamlStr), &cj)
+	cj, err := cs.BatchV1().CronJobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = clientset.BatchV1().CronJobs(namespace).Update(context.TODO(), &cj, metav1.UpdateOptions{})
+	obj, err := toObjectMap(cj, "cronjobs")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	writeObject(c, obj)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+// UpdateCronJob previews or applies YAML changes to a cronjob, see applyYAML
+func UpdateCronJob(c *gin.Context) {
+	applyYAML(c, "cronjobs")
 }
 
 // GetServiceYAML returns YAML of a service
@@ -518,45 +566,30 @@ func GetServiceYAML(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
 		return
 	}
-
-	svc, err := clientset.CoreV1().Services(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	yamlData, err := yaml.Marshal(svc)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizeNamespace(c, namespace, models.VerbGet) {
 		return
 	}
 
-	c.Header("Content-Type", "application/yaml")
-	c.String(http.StatusOK, string(yamlData))
-}
-
-// UpdateService updates a service from YAML
-func UpdateService(c *gin.Context) {
-	namespace := c.Query("ns")
-	name := c.Query("name")
-	yamlStr := c.PostForm("yaml")
-	if namespace == "" || name == "" || yamlStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace, name and yaml required"})
+	cs, ok := clientFor(c)
+	if !ok {
 		return
 	}
 
-	var svc corev1.Service
-	err := yaml.Unmarshal([]byte(yamlStr), &svc)
+	svc, err := cs.CoreV1().Services(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = clientset.CoreV1().Services(namespace).Update(context.TODO(), &svc, metav1.UpdateOptions{})
+	obj, err := toObjectMap(svc, "services")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	writeObject(c, obj)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+// UpdateService previews or applies YAML changes to a service, see applyYAML
+func UpdateService(c *gin.Context) {
+	applyYAML(c, "services")
 }
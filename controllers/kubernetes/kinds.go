@@ -0,0 +1,243 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// typedClient is the subset of a generated typed client used by the generic handlers.
+type typedClient[T runtime.Object] interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (T, error)
+	Create(ctx context.Context, obj T, opts metav1.CreateOptions) (T, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (T, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+}
+
+// kindClient erases the object type of a typedClient.
+type kindClient interface {
+	get(ctx context.Context, name string) (runtime.Object, error)
+	create(ctx context.Context, obj runtime.Object, opts metav1.CreateOptions) (runtime.Object, error)
+	patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions) (runtime.Object, error)
+	delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+}
+
+type typed[T runtime.Object] struct {
+	c typedClient[T]
+}
+
+func (t typed[T]) get(ctx context.Context, name string) (runtime.Object, error) {
+	obj, err := t.c.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (t typed[T]) create(ctx context.Context, obj runtime.Object, opts metav1.CreateOptions) (runtime.Object, error) {
+	o, ok := obj.(T)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	created, err := t.c.Create(ctx, o, opts)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (t typed[T]) patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions) (runtime.Object, error) {
+	obj, err := t.c.Patch(ctx, name, pt, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (t typed[T]) delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return t.c.Delete(ctx, name, opts)
+}
+
+// dynamicKind adapts a dynamic client resource to kindClient.
+type dynamicKind struct {
+	ri dynamic.ResourceInterface
+}
+
+func (d dynamicKind) get(ctx context.Context, name string) (runtime.Object, error) {
+	return d.ri.Get(ctx, name, metav1.GetOptions{})
+}
+
+func (d dynamicKind) create(ctx context.Context, obj runtime.Object, opts metav1.CreateOptions) (runtime.Object, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	return d.ri.Create(ctx, u, opts)
+}
+
+func (d dynamicKind) patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions) (runtime.Object, error) {
+	return d.ri.Patch(ctx, name, pt, data, opts)
+}
+
+func (d dynamicKind) delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return d.ri.Delete(ctx, name, opts)
+}
+
+// resourceKind describes a kind handled by the typed handlers, keyed by its plural
+// resource name as used in the routes. Manifests are created in ascending order so
+// that objects exist before the workloads referencing them.
+type resourceKind struct {
+	gvk       schema.GroupVersionKind
+	order     int
+	newObject func() runtime.Object
+	client    func(cs kubernetes.Interface, namespace string) kindClient
+}
+
+var typedKinds = map[string]resourceKind{
+	"deployments": {
+		order:     50,
+		gvk:       appsv1.SchemeGroupVersion.WithKind("Deployment"),
+		newObject: func() runtime.Object { return &appsv1.Deployment{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*appsv1.Deployment]{cs.AppsV1().Deployments(ns)}
+		},
+	},
+	"daemonsets": {
+		order:     50,
+		gvk:       appsv1.SchemeGroupVersion.WithKind("DaemonSet"),
+		newObject: func() runtime.Object { return &appsv1.DaemonSet{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*appsv1.DaemonSet]{cs.AppsV1().DaemonSets(ns)}
+		},
+	},
+	"statefulsets": {
+		order:     50,
+		gvk:       appsv1.SchemeGroupVersion.WithKind("StatefulSet"),
+		newObject: func() runtime.Object { return &appsv1.StatefulSet{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*appsv1.StatefulSet]{cs.AppsV1().StatefulSets(ns)}
+		},
+	},
+	"jobs": {
+		order:     60,
+		gvk:       batchv1.SchemeGroupVersion.WithKind("Job"),
+		newObject: func() runtime.Object { return &batchv1.Job{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*batchv1.Job]{cs.BatchV1().Jobs(ns)}
+		},
+	},
+	"cronjobs": {
+		order:     60,
+		gvk:       batchv1.SchemeGroupVersion.WithKind("CronJob"),
+		newObject: func() runtime.Object { return &batchv1.CronJob{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*batchv1.CronJob]{cs.BatchV1().CronJobs(ns)}
+		},
+	},
+	"services": {
+		order:     40,
+		gvk:       corev1.SchemeGroupVersion.WithKind("Service"),
+		newObject: func() runtime.Object { return &corev1.Service{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*corev1.Service]{cs.CoreV1().Services(ns)}
+		},
+	},
+	"ingresses": {
+		order:     45,
+		gvk:       networkingv1.SchemeGroupVersion.WithKind("Ingress"),
+		newObject: func() runtime.Object { return &networkingv1.Ingress{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*networkingv1.Ingress]{cs.NetworkingV1().Ingresses(ns)}
+		},
+	},
+	"networkpolicies": {
+		order:     15,
+		gvk:       networkingv1.SchemeGroupVersion.WithKind("NetworkPolicy"),
+		newObject: func() runtime.Object { return &networkingv1.NetworkPolicy{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*networkingv1.NetworkPolicy]{cs.NetworkingV1().NetworkPolicies(ns)}
+		},
+	},
+	"horizontalpodautoscalers": {
+		order:     65,
+		gvk:       autoscalingv2.SchemeGroupVersion.WithKind("HorizontalPodAutoscaler"),
+		newObject: func() runtime.Object { return &autoscalingv2.HorizontalPodAutoscaler{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*autoscalingv2.HorizontalPodAutoscaler]{cs.AutoscalingV2().HorizontalPodAutoscalers(ns)}
+		},
+	},
+	"serviceaccounts": {
+		order:     10,
+		gvk:       corev1.SchemeGroupVersion.WithKind("ServiceAccount"),
+		newObject: func() runtime.Object { return &corev1.ServiceAccount{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*corev1.ServiceAccount]{cs.CoreV1().ServiceAccounts(ns)}
+		},
+	},
+	"secrets": {
+		order:     20,
+		gvk:       corev1.SchemeGroupVersion.WithKind("Secret"),
+		newObject: func() runtime.Object { return &corev1.Secret{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*corev1.Secret]{cs.CoreV1().Secrets(ns)}
+		},
+	},
+	"configmaps": {
+		order:     20,
+		gvk:       corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+		newObject: func() runtime.Object { return &corev1.ConfigMap{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*corev1.ConfigMap]{cs.CoreV1().ConfigMaps(ns)}
+		},
+	},
+	"persistentvolumeclaims": {
+		order:     30,
+		gvk:       corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"),
+		newObject: func() runtime.Object { return &corev1.PersistentVolumeClaim{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*corev1.PersistentVolumeClaim]{cs.CoreV1().PersistentVolumeClaims(ns)}
+		},
+	},
+	"pods": {
+		order:     70,
+		gvk:       corev1.SchemeGroupVersion.WithKind("Pod"),
+		newObject: func() runtime.Object { return &corev1.Pod{} },
+		client: func(cs kubernetes.Interface, ns string) kindClient {
+			return typed[*corev1.Pod]{cs.CoreV1().Pods(ns)}
+		},
+	},
+}
+
+// kindForGVK returns the typed kind registered for gvk.
+func kindForGVK(gvk schema.GroupVersionKind) (string, resourceKind, bool) {
+	for name, rk := range typedKinds {
+		if rk.gvk == gvk {
+			return name, rk, true
+		}
+	}
+	return "", resourceKind{}, false
+}
+
+// toObjectMap converts a typed object to its JSON map form with apiVersion and kind set.
+func toObjectMap(obj runtime.Object, kind string) (map[string]interface{}, error) {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	apiVersion, k := typedKinds[kind].gvk.ToAPIVersionAndKind()
+	m["apiVersion"] = apiVersion
+	m["kind"] = k
+	return m, nil
+}
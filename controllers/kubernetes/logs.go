@@ -0,0 +1,103 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+
+	"gin-demo/models"
+)
+
+// logChunkSize is the read buffer used when relaying log streams.
+const logChunkSize = 32 * 1024
+
+// GetPodLogs streams container logs of a pod chunk by chunk.
+// Query: ns, name, container, follow, previous, timestamps, tailLines, sinceSeconds, download.
+func GetPodLogs(c *gin.Context) {
+	namespace := c.Query("ns")
+	name := c.Query("name")
+	if namespace == "" || name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace and name required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbGet) {
+		return
+	}
+
+	opts := &corev1.PodLogOptions{
+		Container:  c.Query("container"),
+		Follow:     c.Query("follow") == "true",
+		Previous:   c.Query("previous") == "true",
+		Timestamps: c.Query("timestamps") == "true",
+	}
+	if v := c.Query("tailLines"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tailLines"})
+			return
+		}
+		opts.TailLines = &n
+	}
+	if v := c.Query("sinceSeconds"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sinceSeconds"})
+			return
+		}
+		opts.SinceSeconds = &n
+	}
+	download := c.Query("download") == "true"
+	if download {
+		// a download is a snapshot, never an endless stream
+		opts.Follow = false
+	}
+
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	stream, err := cs.CoreV1().Pods(namespace).GetLogs(name, opts).Stream(c.Request.Context())
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer stream.Close()
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "no-cache")
+	if download {
+		filename := name
+		if opts.Container != "" {
+			filename += "-" + opts.Container
+		}
+		if opts.Previous {
+			filename += "-previous"
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".log"))
+	}
+	c.Status(http.StatusOK)
+
+	buf := make([]byte, logChunkSize)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			if err != io.EOF && c.Request.Context().Err() == nil {
+				logrus.Warnf("k8s: log stream %s/%s ended: %v", namespace, name, err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kjson "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"gin-demo/models"
+)
+
+// strictDecoder rejects unknown and duplicate fields instead of dropping them.
+var strictDecoder = kjson.NewSerializerWithOptions(kjson.DefaultMetaFactory, scheme.Scheme, scheme.Scheme,
+	kjson.SerializerOptions{Strict: true})
+
+// manifestObject is one decoded document of a manifest.
+type manifestObject struct {
+	rk        resourceKind
+	obj       runtime.Object
+	namespace string
+	name      string
+}
+
+// manifestResult reports what happened to one manifest object.
+type manifestResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Status    string `json:"status"` // created, failed or skipped
+	Error     string `json:"error,omitempty"`
+}
+
+// splitManifest splits a multi-document YAML or JSON manifest into JSON documents.
+// Lists are expanded into their items.
+func splitManifest(manifest string) ([][]byte, error) {
+	var docs [][]byte
+	d := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(manifest), 4096)
+	for {
+		var raw runtime.RawExtension
+		if err := d.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		doc := bytes.TrimSpace(raw.Raw)
+		if len(doc) == 0 || bytes.Equal(doc, []byte("null")) {
+			continue
+		}
+		var list struct {
+			Kind  string            `json:"kind"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(doc, &list); err == nil && strings.HasSuffix(list.Kind, "List") && list.Items != nil {
+			for _, item := range list.Items {
+				docs = append(docs, item)
+			}
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// decodeManifest decodes every document into a typed object of a supported kind.
+// Objects without a namespace are placed in defaultNamespace.
+func decodeManifest(manifest, defaultNamespace string) ([]manifestObject, error) {
+	docs, err := splitManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, errors.New("manifest contains no objects")
+	}
+	objs := make([]manifestObject, 0, len(docs))
+	for i, doc := range docs {
+		var tm metav1.TypeMeta
+		if err := json.Unmarshal(doc, &tm); err != nil {
+			return nil, fmt.Errorf("document %d: %v", i+1, err)
+		}
+		_, rk, ok := kindForGVK(tm.GroupVersionKind())
+		if !ok {
+			return nil, fmt.Errorf("document %d: unsupported kind %q (apiVersion %q)", i+1, tm.Kind, tm.APIVersion)
+		}
+		obj, _, err := strictDecoder.Decode(doc, nil, rk.newObject())
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i+1, err)
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i+1, err)
+		}
+		if accessor.GetName() == "" {
+			return nil, fmt.Errorf("document %d: metadata.name required", i+1)
+		}
+		if accessor.GetNamespace() == "" {
+			accessor.SetNamespace(defaultNamespace)
+		}
+		if accessor.GetNamespace() == "" {
+			return nil, fmt.Errorf("document %d: namespace required", i+1)
+		}
+		objs = append(objs, manifestObject{
+			rk:        rk,
+			obj:       obj,
+			namespace: accessor.GetNamespace(),
+			name:      accessor.GetName(),
+		})
+	}
+	// dependencies first, keeping the manifest order within a group
+	sort.SliceStable(objs, func(i, j int) bool { return objs[i].rk.order < objs[j].rk.order })
+	return objs, nil
+}
+
+// CreateFromManifest creates the objects of a multi-document YAML or JSON manifest
+// (form field "manifest"). ns is the namespace for objects that do not set one.
+// All documents are validated and authorized before anything is created; creation
+// stops at the first failure and the remaining objects are reported as skipped.
+// dryRun=true validates against the apiserver without persisting. Manifests with
+// objects in a protected namespace are refused (409).
+func CreateFromManifest(c *gin.Context) {
+	manifest := c.PostForm("manifest")
+	if manifest == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "manifest required"})
+		return
+	}
+	objs, err := decodeManifest(manifest, c.Query("ns"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dryRun := c.Query("dryRun") == "true"
+	checked := map[string]bool{}
+	for _, o := range objs {
+		if checked[o.namespace] {
+			continue
+		}
+		if !authorizeNamespace(c, o.namespace, models.VerbCreate) {
+			return
+		}
+		if !dryRun && !requireUnprotected(c, o.namespace) {
+			return
+		}
+		checked[o.namespace] = true
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	opts := metav1.CreateOptions{FieldManager: fieldManager}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+		skipAudit(c)
+	}
+	ctx := context.TODO()
+	results := make([]manifestResult, 0, len(objs))
+	var created []runtime.Object
+	failed := false
+	for _, o := range objs {
+		r := manifestResult{Kind: o.rk.gvk.Kind, Namespace: o.namespace, Name: o.name}
+		if failed {
+			r.Status = "skipped"
+		} else if obj, err := o.rk.client(cs, o.namespace).create(ctx, o.obj, opts); err != nil {
+			r.Status = "failed"
+			r.Error = err.Error()
+			failed = true
+		} else {
+			r.Status = "created"
+			created = append(created, obj)
+		}
+		results = append(results, r)
+	}
+
+	rec := auditFor(c)
+	if detail, err := json.Marshal(results); err == nil {
+		rec.detail = string(detail)
+	}
+	rec.after = objectHash(created)
+	status := http.StatusCreated
+	if failed {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{"dryRun": opts.DryRun != nil, "results": results})
+}
+
+// deletePropagation maps the propagationPolicy query value to the API policy.
+var deletePropagation = map[string]metav1.DeletionPropagation{
+	"":           metav1.DeletePropagationBackground,
+	"background": metav1.DeletePropagationBackground,
+	"foreground": metav1.DeletePropagationForeground,
+	"orphan":     metav1.DeletePropagationOrphan,
+}
+
+// deleteResource returns a handler deleting one object of kind. The confirm query
+// parameter must repeat the object name; propagationPolicy is background (default),
+// foreground or orphan. Objects of protected namespaces are not deleted (409).
+func deleteResource(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cs, namespace, name, ok := workloadRequest(c, models.VerbDelete)
+		if !ok {
+			return
+		}
+		if c.Query("confirm") != name {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "confirm must repeat the name of the resource to delete"})
+			return
+		}
+		policy, ok := deletePropagation[strings.ToLower(c.Query("propagationPolicy"))]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "propagationPolicy must be foreground, background or orphan"})
+			return
+		}
+		if !requireUnprotected(c, namespace) {
+			return
+		}
+
+		kc := typedKinds[kind].client(cs, namespace)
+		ctx := context.TODO()
+		live, err := kc.get(ctx, name)
+		if err != nil {
+			c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		auditFor(c).before = objectHash(live)
+		if err := kc.delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil {
+			c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "deleted", "propagationPolicy": policy})
+	}
+}
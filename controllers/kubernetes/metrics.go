@@ -0,0 +1,322 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"gin-demo/models"
+)
+
+// metrics.k8s.io is served by metrics-server, which is not installed everywhere
+var (
+	podMetricsGVR  = schema.GroupVersionResource{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "pods"}
+	nodeMetricsGVR = schema.GroupVersionResource{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "nodes"}
+
+	errMetricsUnavailable = errors.New("metrics unavailable: metrics-server is not installed or not ready")
+)
+
+// usageValue is the usage of one resource compared to what was requested, the limit
+// or, for nodes, what is allocatable. Percentages are omitted when there is no base.
+type usageValue struct {
+	Usage                string   `json:"usage"`
+	Request              string   `json:"request,omitempty"`
+	Limit                string   `json:"limit,omitempty"`
+	Allocatable          string   `json:"allocatable,omitempty"`
+	PercentOfRequest     *float64 `json:"percentOfRequest,omitempty"`
+	PercentOfLimit       *float64 `json:"percentOfLimit,omitempty"`
+	PercentOfAllocatable *float64 `json:"percentOfAllocatable,omitempty"`
+}
+
+// resourceUsage is the cpu and memory usage of a pod or node.
+type resourceUsage struct {
+	CPU    usageValue `json:"cpu"`
+	Memory usageValue `json:"memory"`
+	// raw usage for sorting
+	usage corev1.ResourceList
+}
+
+// podUsage is a pod in the top-style pod list.
+type podUsage struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Node      string `json:"node,omitempty"`
+	resourceUsage
+}
+
+// nodeUsage is a node in the top-style node list.
+type nodeUsage struct {
+	Name string `json:"name"`
+	resourceUsage
+}
+
+// metricsError maps the errors returned while metrics-server is absent or down to
+// errMetricsUnavailable.
+func metricsError(err error) error {
+	if apierrors.IsNotFound(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsTimeout(err) {
+		return errMetricsUnavailable
+	}
+	return err
+}
+
+// parseUsage reads the cpu and memory quantities of a metrics usage map.
+func parseUsage(m map[string]interface{}) corev1.ResourceList {
+	rl := corev1.ResourceList{}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		s, _ := m[string(name)].(string)
+		if q, err := resource.ParseQuantity(s); err == nil {
+			rl[name] = q
+		}
+	}
+	return rl
+}
+
+// listPodMetrics returns the summed container usage of the pods in namespace, by
+// "namespace/name".
+func (cl *cluster) listPodMetrics(ctx context.Context, namespace, selector string) (map[string]corev1.ResourceList, error) {
+	list, err := cl.dynamic.Resource(podMetricsGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, metricsError(err)
+	}
+	out := make(map[string]corev1.ResourceList, len(list.Items))
+	for _, item := range list.Items {
+		total := corev1.ResourceList{}
+		containers, _, _ := unstructured.NestedSlice(item.Object, "containers")
+		for _, ct := range containers {
+			m, _ := ct.(map[string]interface{})
+			usage, _, _ := unstructured.NestedMap(m, "usage")
+			addResources(total, parseUsage(usage))
+		}
+		out[item.GetNamespace()+"/"+item.GetName()] = total
+	}
+	return out, nil
+}
+
+// listNodeMetrics returns the usage of every node by name.
+func (cl *cluster) listNodeMetrics(ctx context.Context) (map[string]corev1.ResourceList, error) {
+	list, err := cl.dynamic.Resource(nodeMetricsGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, metricsError(err)
+	}
+	out := make(map[string]corev1.ResourceList, len(list.Items))
+	for _, item := range list.Items {
+		usage, _, _ := unstructured.NestedMap(item.Object, "usage")
+		out[item.GetName()] = parseUsage(usage)
+	}
+	return out, nil
+}
+
+func addResources(total, rl corev1.ResourceList) {
+	for name, q := range rl {
+		sum := total[name]
+		sum.Add(q)
+		total[name] = sum
+	}
+}
+
+// podRequestsLimits sums the requests and limits of the regular containers of a pod.
+func podRequestsLimits(p *corev1.Pod) (corev1.ResourceList, corev1.ResourceList) {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, ct := range p.Spec.Containers {
+		addResources(requests, ct.Resources.Requests)
+		addResources(limits, ct.Resources.Limits)
+	}
+	return requests, limits
+}
+
+// percent returns usage as a percentage of base rounded to one decimal, or nil
+// without a base.
+func percent(usage, base resource.Quantity, name corev1.ResourceName) *float64 {
+	if base.IsZero() {
+		return nil
+	}
+	var p float64
+	if name == corev1.ResourceCPU {
+		p = float64(usage.MilliValue()) / float64(base.MilliValue()) * 100
+	} else {
+		p = float64(usage.Value()) / float64(base.Value()) * 100
+	}
+	p = math.Round(p*10) / 10
+	return &p
+}
+
+func quantityString(rl corev1.ResourceList, name corev1.ResourceName) string {
+	if q, ok := rl[name]; ok {
+		return q.String()
+	}
+	return ""
+}
+
+// newUsageValue compares the usage of resource name with requests, limits and allocatable;
+// any of them may be nil.
+func newUsageValue(name corev1.ResourceName, usage, requests, limits, allocatable corev1.ResourceList) usageValue {
+	u := usage[name]
+	return usageValue{
+		Usage:                u.String(),
+		Request:              quantityString(requests, name),
+		Limit:                quantityString(limits, name),
+		Allocatable:          quantityString(allocatable, name),
+		PercentOfRequest:     percent(u, requests[name], name),
+		PercentOfLimit:       percent(u, limits[name], name),
+		PercentOfAllocatable: percent(u, allocatable[name], name),
+	}
+}
+
+func newPodResourceUsage(p *corev1.Pod, usage corev1.ResourceList) resourceUsage {
+	requests, limits := podRequestsLimits(p)
+	return resourceUsage{
+		CPU:    newUsageValue(corev1.ResourceCPU, usage, requests, limits, nil),
+		Memory: newUsageValue(corev1.ResourceMemory, usage, requests, limits, nil),
+		usage:  usage,
+	}
+}
+
+func newNodeResourceUsage(n *corev1.Node, usage corev1.ResourceList) resourceUsage {
+	return resourceUsage{
+		CPU:    newUsageValue(corev1.ResourceCPU, usage, nil, nil, n.Status.Allocatable),
+		Memory: newUsageValue(corev1.ResourceMemory, usage, nil, nil, n.Status.Allocatable),
+		usage:  usage,
+	}
+}
+
+// addPodMetrics adds the usage of pods to a pod list response under metrics, keyed by
+// pod name. Metrics never fail the response: without them metricsAvailable is false
+// and metricsError explains why.
+func addPodMetrics(ctx context.Context, res gin.H, cl *cluster, namespace, selector string, pods []*corev1.Pod) {
+	usage, err := cl.listPodMetrics(ctx, namespace, selector)
+	if err != nil {
+		res["metricsAvailable"] = false
+		res["metricsError"] = err.Error()
+		return
+	}
+	metrics := make(map[string]resourceUsage, len(pods))
+	for _, p := range pods {
+		if u, ok := usage[p.Namespace+"/"+p.Name]; ok {
+			metrics[p.Name] = newPodResourceUsage(p, u)
+		}
+	}
+	res["metricsAvailable"] = true
+	res["metrics"] = metrics
+}
+
+// writeMetricsUnavailable answers a top request when metrics-server is missing.
+func writeMetricsUnavailable(c *gin.Context, key string, err error) {
+	if errors.Is(err, errMetricsUnavailable) {
+		c.JSON(http.StatusOK, gin.H{key: []interface{}{}, "metricsAvailable": false, "metricsError": err.Error()})
+		return
+	}
+	c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+}
+
+// compareUsage compares memory usage with sort=memory and cpu usage otherwise.
+func compareUsage(by string, a, b resourceUsage) int {
+	name := corev1.ResourceCPU
+	if by == "memory" {
+		name = corev1.ResourceMemory
+	}
+	x, y := a.usage[name], b.usage[name]
+	return x.Cmp(y)
+}
+
+// TopNodes returns the cpu and memory usage of every node against its allocatable
+// resources, highest cpu first (sort=memory orders by memory). Needs a grant on "*".
+func TopNodes(c *gin.Context) {
+	if !authorizeNamespace(c, models.AllNamespaces, models.VerbList) {
+		return
+	}
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.TODO()
+	usage, err := cl.listNodeMetrics(ctx)
+	if err != nil {
+		writeMetricsUnavailable(c, "nodes", err)
+		return
+	}
+	nodes, err := cl.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	out := make([]nodeUsage, 0, len(nodes.Items))
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+		if u, ok := usage[n.Name]; ok {
+			out = append(out, nodeUsage{Name: n.Name, resourceUsage: newNodeResourceUsage(n, u)})
+		}
+	}
+	by := c.Query("sort")
+	sort.Slice(out, func(i, j int) bool {
+		if cmp := compareUsage(by, out[i].resourceUsage, out[j].resourceUsage); cmp != 0 {
+			return cmp > 0
+		}
+		return out[i].Name < out[j].Name
+	})
+	c.JSON(http.StatusOK, gin.H{"nodes": out, "metricsAvailable": true})
+}
+
+// TopPods returns the usage of the pods in ns (all namespaces when omitted, which
+// needs a grant on "*") against their requests and limits, highest cpu first
+// (sort=memory orders by memory). labelSelector narrows the pods.
+func TopPods(c *gin.Context) {
+	namespace := c.Query("ns")
+	scope := namespace
+	if scope == "" {
+		scope = models.AllNamespaces
+	}
+	if !authorizeNamespace(c, scope, models.VerbList) {
+		return
+	}
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.TODO()
+	selector := c.Query("labelSelector")
+	usage, err := cl.listPodMetrics(ctx, namespace, selector)
+	if err != nil {
+		writeMetricsUnavailable(c, "pods", err)
+		return
+	}
+	pods, err := cl.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	out := make([]podUsage, 0, len(pods.Items))
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		if u, ok := usage[p.Namespace+"/"+p.Name]; ok {
+			out = append(out, podUsage{
+				Namespace:     p.Namespace,
+				Name:          p.Name,
+				Node:          p.Spec.NodeName,
+				resourceUsage: newPodResourceUsage(p, u),
+			})
+		}
+	}
+	by := c.Query("sort")
+	sort.Slice(out, func(i, j int) bool {
+		if cmp := compareUsage(by, out[i].resourceUsage, out[j].resourceUsage); cmp != 0 {
+			return cmp > 0
+		}
+		if out[i].Namespace != out[j].Namespace {
+			return out[i].Namespace < out[j].Namespace
+		}
+		return out[i].Name < out[j].Name
+	})
+	c.JSON(http.StatusOK, gin.H{"pods": out, "metricsAvailable": true})
+}
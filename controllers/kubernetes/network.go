@@ -0,0 +1,279 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"gin-demo/models"
+)
+
+// ingressBackend is an ingress backend resolved against the services of the namespace.
+type ingressBackend struct {
+	Service string `json:"service,omitempty"`
+	Port    string `json:"port,omitempty"`
+	// Resource is set for resource backends instead of Service
+	Resource string `json:"resource,omitempty"`
+	// Found is false when the service or its port does not exist
+	Found       bool   `json:"found"`
+	ServiceType string `json:"serviceType,omitempty"`
+	ClusterIP   string `json:"clusterIP,omitempty"`
+	TargetPort  string `json:"targetPort,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ingressPath is one host/path rule of an ingress.
+type ingressPath struct {
+	Host     string         `json:"host"`
+	Path     string         `json:"path"`
+	PathType string         `json:"pathType,omitempty"`
+	Backend  ingressBackend `json:"backend"`
+}
+
+// ingressView summarises an ingress with its rules resolved to services.
+type ingressView struct {
+	Name           string          `json:"name"`
+	Namespace      string          `json:"namespace"`
+	Class          string          `json:"class,omitempty"`
+	Addresses      []string        `json:"addresses"`
+	TLSHosts       []string        `json:"tlsHosts"`
+	DefaultBackend *ingressBackend `json:"defaultBackend,omitempty"`
+	Rules          []ingressPath   `json:"rules"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+// resolveBackend looks up the service and port of an ingress backend.
+func resolveBackend(b networkingv1.IngressBackend, services map[string]*corev1.Service) ingressBackend {
+	if b.Resource != nil {
+		return ingressBackend{Resource: b.Resource.Kind + "/" + b.Resource.Name, Found: true}
+	}
+	if b.Service == nil {
+		return ingressBackend{Error: "no backend"}
+	}
+	out := ingressBackend{Service: b.Service.Name, Port: b.Service.Port.Name}
+	if out.Port == "" {
+		out.Port = strconv.Itoa(int(b.Service.Port.Number))
+	}
+	svc, ok := services[b.Service.Name]
+	if !ok {
+		out.Error = "service not found"
+		return out
+	}
+	out.ServiceType = string(svc.Spec.Type)
+	out.ClusterIP = svc.Spec.ClusterIP
+	for _, p := range svc.Spec.Ports {
+		if (b.Service.Port.Name != "" && p.Name == b.Service.Port.Name) ||
+			(b.Service.Port.Name == "" && p.Port == b.Service.Port.Number) {
+			out.Found = true
+			out.TargetPort = p.TargetPort.String()
+			return out
+		}
+	}
+	out.Error = "service has no port " + out.Port
+	return out
+}
+
+func newIngressView(ing *networkingv1.Ingress, services map[string]*corev1.Service) ingressView {
+	v := ingressView{
+		Name:      ing.Name,
+		Namespace: ing.Namespace,
+		Addresses: []string{},
+		TLSHosts:  []string{},
+		Rules:     []ingressPath{},
+		CreatedAt: ing.CreationTimestamp.Time,
+	}
+	if ing.Spec.IngressClassName != nil {
+		v.Class = *ing.Spec.IngressClassName
+	}
+	for _, lb := range ing.Status.LoadBalancer.Ingress {
+		if lb.IP != "" {
+			v.Addresses = append(v.Addresses, lb.IP)
+		} else if lb.Hostname != "" {
+			v.Addresses = append(v.Addresses, lb.Hostname)
+		}
+	}
+	for _, tls := range ing.Spec.TLS {
+		v.TLSHosts = append(v.TLSHosts, tls.Hosts...)
+	}
+	if ing.Spec.DefaultBackend != nil {
+		b := resolveBackend(*ing.Spec.DefaultBackend, services)
+		v.DefaultBackend = &b
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			ip := ingressPath{Host: rule.Host, Path: p.Path, Backend: resolveBackend(p.Backend, services)}
+			if p.PathType != nil {
+				ip.PathType = string(*p.PathType)
+			}
+			v.Rules = append(v.Rules, ip)
+		}
+	}
+	return v
+}
+
+// GetIngresses returns the ingresses of a namespace with their rules and backends
+// resolved to services
+func GetIngresses(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.TODO()
+	ingresses, err := cs.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	svcList, err := cs.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	services := make(map[string]*corev1.Service, len(svcList.Items))
+	for i := range svcList.Items {
+		services[svcList.Items[i].Name] = &svcList.Items[i]
+	}
+
+	views := make([]ingressView, 0, len(ingresses.Items))
+	for i := range ingresses.Items {
+		views = append(views, newIngressView(&ingresses.Items[i], services))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	c.JSON(http.StatusOK, gin.H{"ingresses": views, "source": sourceLive})
+}
+
+// GetIngressYAML returns YAML of an ingress
+func GetIngressYAML(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbGet)
+	if !ok {
+		return
+	}
+	ing, err := cs.NetworkingV1().Ingresses(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	obj, err := toObjectMap(ing, "ingresses")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeObject(c, obj)
+}
+
+// UpdateIngress previews or applies YAML changes to an ingress, see applyYAML
+func UpdateIngress(c *gin.Context) {
+	applyYAML(c, "ingresses")
+}
+
+// networkPolicyView summarises a network policy; the rules are returned as in the spec.
+type networkPolicyView struct {
+	Name        string                                  `json:"name"`
+	Namespace   string                                  `json:"namespace"`
+	PodSelector string                                  `json:"podSelector"`
+	PolicyTypes []networkingv1.PolicyType               `json:"policyTypes"`
+	Ingress     []networkingv1.NetworkPolicyIngressRule `json:"ingress"`
+	Egress      []networkingv1.NetworkPolicyEgressRule  `json:"egress"`
+	CreatedAt   time.Time                               `json:"createdAt"`
+}
+
+func newNetworkPolicyView(np *networkingv1.NetworkPolicy) networkPolicyView {
+	v := networkPolicyView{
+		Name:        np.Name,
+		Namespace:   np.Namespace,
+		PodSelector: metav1.FormatLabelSelector(&np.Spec.PodSelector),
+		PolicyTypes: np.Spec.PolicyTypes,
+		Ingress:     np.Spec.Ingress,
+		Egress:      np.Spec.Egress,
+		CreatedAt:   np.CreationTimestamp.Time,
+	}
+	if v.PodSelector == "<none>" {
+		// an empty selector selects every pod of the namespace
+		v.PodSelector = ""
+	}
+	if v.PolicyTypes == nil {
+		// defaulted by the apiserver, like kubectl describe
+		v.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+		if len(np.Spec.Egress) > 0 {
+			v.PolicyTypes = append(v.PolicyTypes, networkingv1.PolicyTypeEgress)
+		}
+	}
+	if v.Ingress == nil {
+		v.Ingress = []networkingv1.NetworkPolicyIngressRule{}
+	}
+	if v.Egress == nil {
+		v.Egress = []networkingv1.NetworkPolicyEgressRule{}
+	}
+	return v
+}
+
+// GetNetworkPolicies returns the network policies of a namespace
+func GetNetworkPolicies(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	policies, err := cs.NetworkingV1().NetworkPolicies(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	views := make([]networkPolicyView, 0, len(policies.Items))
+	for i := range policies.Items {
+		views = append(views, newNetworkPolicyView(&policies.Items[i]))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	c.JSON(http.StatusOK, gin.H{"networkpolicies": views, "source": sourceLive})
+}
+
+// GetNetworkPolicyYAML returns YAML of a network policy
+func GetNetworkPolicyYAML(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbGet)
+	if !ok {
+		return
+	}
+	np, err := cs.NetworkingV1().NetworkPolicies(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	obj, err := toObjectMap(np, "networkpolicies")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeObject(c, obj)
+}
+
+// UpdateNetworkPolicy previews or applies YAML changes to a network policy, see applyYAML
+func UpdateNetworkPolicy(c *gin.Context) {
+	applyYAML(c, "networkpolicies")
+}
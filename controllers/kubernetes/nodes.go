@@ -0,0 +1,549 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"gin-demo/models"
+)
+
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+var (
+	drainDefaultTimeout = 10 * time.Minute
+	drainMaxTimeout     = time.Hour
+	// evictions blocked by a PodDisruptionBudget are retried at this interval
+	drainRetryInterval = 5 * time.Second
+	// finished drains are kept this long for status queries
+	drainRetention = time.Hour
+)
+
+// nodeCondition is a node condition as shown in the node list.
+type nodeCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// nodeView summarises a node for the node list.
+type nodeView struct {
+	Name           string            `json:"name"`
+	Roles          []string          `json:"roles"`
+	Ready          bool              `json:"ready"`
+	Unschedulable  bool              `json:"unschedulable"`
+	Conditions     []nodeCondition   `json:"conditions"`
+	Taints         []corev1.Taint    `json:"taints"`
+	Capacity       map[string]string `json:"capacity"`
+	Allocatable    map[string]string `json:"allocatable"`
+	Pods           int               `json:"pods"`
+	Usage          *resourceUsage    `json:"usage,omitempty"`
+	InternalIP     string            `json:"internalIP,omitempty"`
+	KubeletVersion string            `json:"kubeletVersion"`
+	CreatedAt      time.Time         `json:"createdAt"`
+}
+
+func resourceListStrings(rl corev1.ResourceList) map[string]string {
+	m := make(map[string]string, len(rl))
+	for name, q := range rl {
+		m[string(name)] = q.String()
+	}
+	return m
+}
+
+func newNodeView(n *corev1.Node, pods int) nodeView {
+	v := nodeView{
+		Name:           n.Name,
+		Roles:          []string{},
+		Unschedulable:  n.Spec.Unschedulable,
+		Conditions:     []nodeCondition{},
+		Taints:         n.Spec.Taints,
+		Capacity:       resourceListStrings(n.Status.Capacity),
+		Allocatable:    resourceListStrings(n.Status.Allocatable),
+		Pods:           pods,
+		KubeletVersion: n.Status.NodeInfo.KubeletVersion,
+		CreatedAt:      n.CreationTimestamp.Time,
+	}
+	for label := range n.Labels {
+		if strings.HasPrefix(label, nodeRoleLabelPrefix) {
+			v.Roles = append(v.Roles, strings.TrimPrefix(label, nodeRoleLabelPrefix))
+		}
+	}
+	sort.Strings(v.Roles)
+	for _, cond := range n.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			v.Ready = cond.Status == corev1.ConditionTrue
+		}
+		v.Conditions = append(v.Conditions, nodeCondition{
+			Type:    string(cond.Type),
+			Status:  string(cond.Status),
+			Reason:  cond.Reason,
+			Message: cond.Message,
+		})
+	}
+	for _, addr := range n.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP {
+			v.InternalIP = addr.Address
+			break
+		}
+	}
+	if v.Taints == nil {
+		v.Taints = []corev1.Taint{}
+	}
+	return v
+}
+
+// nodeRequest checks that the user holds verb on all namespaces, as nodes are
+// cluster-scoped, and returns the cluster client and the node name.
+func nodeRequest(c *gin.Context, verb string) (kubernetes.Interface, string, bool) {
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+		return nil, "", false
+	}
+	if !authorizeNamespace(c, models.AllNamespaces, verb) {
+		return nil, "", false
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return nil, "", false
+	}
+	return cs, name, true
+}
+
+// GetNodes lists nodes with capacity, allocatable, conditions, taints, the number
+// of running pods and, when metrics-server is available, usage. Needs a grant on
+// all namespaces.
+func GetNodes(c *gin.Context) {
+	if !authorizeNamespace(c, models.AllNamespaces, models.VerbList) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.TODO()
+	nodes, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	pods, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	perNode := map[string]int{}
+	for _, p := range pods.Items {
+		if p.Spec.NodeName == "" || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		perNode[p.Spec.NodeName]++
+	}
+
+	cl, _ := clusterFor(c)
+	usage, metricsErr := cl.listNodeMetrics(ctx)
+
+	views := make([]nodeView, 0, len(nodes.Items))
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+		v := newNodeView(n, perNode[n.Name])
+		if u, ok := usage[n.Name]; ok {
+			nu := newNodeResourceUsage(n, u)
+			v.Usage = &nu
+		}
+		views = append(views, v)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	res := gin.H{"nodes": views, "metricsAvailable": metricsErr == nil}
+	if metricsErr != nil {
+		res["metricsError"] = metricsErr.Error()
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// setUnschedulable cordons or uncordons node and records its hashes before and after
+// in rec.
+func setUnschedulable(ctx context.Context, cs kubernetes.Interface, rec *auditRecord, node *corev1.Node, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	rec.before = objectHash(node)
+	patched, err := cs.CoreV1().Nodes().Patch(ctx, node.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		return err
+	}
+	rec.after = objectHash(patched)
+	return nil
+}
+
+func cordonHandler(c *gin.Context, unschedulable bool) {
+	cs, name, ok := nodeRequest(c, models.VerbUpdate)
+	if !ok {
+		return
+	}
+	ctx := context.TODO()
+	node, err := cs.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := setUnschedulable(ctx, cs, auditFor(c), node, unschedulable); err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "updated", "unschedulable": unschedulable})
+}
+
+// CordonNode marks a node unschedulable
+func CordonNode(c *gin.Context) { cordonHandler(c, true) }
+
+// UncordonNode marks a node schedulable again
+func UncordonNode(c *gin.Context) { cordonHandler(c, false) }
+
+// drain pod states
+const (
+	drainPodPending = "pending"
+	drainPodBlocked = "blocked" // eviction refused by a PodDisruptionBudget, retrying
+	drainPodEvicted = "evicted" // eviction accepted, waiting for the pod to go away
+	drainPodDone    = "deleted"
+	drainPodFailed  = "failed"
+	drainPodSkipped = "skipped"
+)
+
+// drain states
+const (
+	drainRunning   = "running"
+	drainSucceeded = "succeeded"
+	drainFailed    = "failed"
+)
+
+type drainPod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+	uid       types.UID
+}
+
+// drainStatus is the progress of a drain as returned to the UI.
+type drainStatus struct {
+	ID         string     `json:"id"`
+	Cluster    string     `json:"cluster"`
+	Node       string     `json:"node"`
+	User       string     `json:"user"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	Pods       []drainPod `json:"pods"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// drainOperation is an asynchronous node drain whose progress is polled by the UI.
+type drainOperation struct {
+	mu     sync.Mutex
+	status drainStatus
+}
+
+// drainOptions mirror the kubectl drain flags.
+type drainOptions struct {
+	force              bool
+	ignoreDaemonSets   bool
+	deleteEmptyDirData bool
+	gracePeriodSeconds *int64
+	timeout            time.Duration
+}
+
+var (
+	drainsMu sync.Mutex
+	drains   = map[string]*drainOperation{}
+	drainSeq int
+)
+
+func (d *drainOperation) setPod(i int, status, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status.Pods[i].Status = status
+	d.status.Pods[i].Message = message
+}
+
+func (d *drainOperation) finish(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.status.FinishedAt = &now
+	d.status.State = drainSucceeded
+	if err != nil {
+		d.status.State = drainFailed
+		d.status.Error = err.Error()
+	}
+}
+
+// snapshot returns a copy of the status that is safe to serialise.
+func (d *drainOperation) snapshot() drainStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.status
+	s.Pods = append([]drainPod(nil), d.status.Pods...)
+	return s
+}
+
+// drainablePods selects the pods to evict like kubectl drain and explains why the
+// others are skipped. It fails when a pod can only be removed with force or
+// deleteEmptyDirData.
+func drainablePods(pods []corev1.Pod, opts drainOptions) ([]drainPod, error) {
+	var out []drainPod
+	var problems []string
+	for _, p := range pods {
+		dp := drainPod{Namespace: p.Namespace, Name: p.Name, Status: drainPodPending, uid: p.UID}
+		if _, mirror := p.Annotations[corev1.MirrorPodAnnotationKey]; mirror {
+			dp.Status, dp.Message = drainPodSkipped, "mirror pod"
+			out = append(out, dp)
+			continue
+		}
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			// finished pods hold no data and do not count against budgets
+			out = append(out, dp)
+			continue
+		}
+		owner := metav1.GetControllerOf(&p)
+		if owner != nil && owner.Kind == "DaemonSet" {
+			if !opts.ignoreDaemonSets {
+				problems = append(problems, fmt.Sprintf("%s/%s is managed by a DaemonSet (set ignoreDaemonSets=true)", p.Namespace, p.Name))
+				continue
+			}
+			dp.Status, dp.Message = drainPodSkipped, "daemonset pod"
+			out = append(out, dp)
+			continue
+		}
+		if owner == nil && !opts.force {
+			problems = append(problems, fmt.Sprintf("%s/%s is not managed by a controller (set force=true)", p.Namespace, p.Name))
+			continue
+		}
+		if !opts.deleteEmptyDirData {
+			for _, vol := range p.Spec.Volumes {
+				if vol.EmptyDir != nil {
+					problems = append(problems, fmt.Sprintf("%s/%s uses emptyDir volume %s (set deleteEmptyDirData=true)", p.Namespace, p.Name, vol.Name))
+					break
+				}
+			}
+		}
+		out = append(out, dp)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("cannot drain: %s", strings.Join(problems, "; "))
+	}
+	return out, nil
+}
+
+// runDrain evicts the pending pods of d in parallel, retrying evictions refused by
+// a PodDisruptionBudget, and waits for the evicted pods to disappear.
+func runDrain(ctx context.Context, cs kubernetes.Interface, d *drainOperation, opts drainOptions) error {
+	var wg sync.WaitGroup
+	pods := d.snapshot().Pods
+	errs := make([]error, len(pods))
+	for i, p := range pods {
+		if p.Status != drainPodPending {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, p drainPod) {
+			defer wg.Done()
+			errs[i] = evictPod(ctx, cs, d, i, p, opts)
+		}(i, p)
+	}
+	wg.Wait()
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, pods[i].Namespace+"/"+pods[i].Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("pods not evicted: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func evictPod(ctx context.Context, cs kubernetes.Interface, d *drainOperation, i int, p drainPod, opts drainOptions) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Name: p.Name, Namespace: p.Namespace},
+		DeleteOptions: &metav1.DeleteOptions{GracePeriodSeconds: opts.gracePeriodSeconds},
+	}
+	for {
+		err := cs.PolicyV1().Evictions(p.Namespace).Evict(ctx, eviction)
+		if err == nil || apierrors.IsNotFound(err) {
+			break
+		}
+		if !apierrors.IsTooManyRequests(err) {
+			d.setPod(i, drainPodFailed, err.Error())
+			return err
+		}
+		d.setPod(i, drainPodBlocked, err.Error())
+		select {
+		case <-ctx.Done():
+			d.setPod(i, drainPodFailed, "timed out: "+err.Error())
+			return ctx.Err()
+		case <-time.After(drainRetryInterval):
+		}
+	}
+
+	d.setPod(i, drainPodEvicted, "")
+	for {
+		pod, err := cs.CoreV1().Pods(p.Namespace).Get(ctx, p.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && pod.UID != p.uid) {
+			d.setPod(i, drainPodDone, "")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			d.setPod(i, drainPodFailed, "timed out waiting for deletion")
+			return ctx.Err()
+		case <-time.After(drainRetryInterval):
+		}
+	}
+}
+
+func pruneDrains() {
+	for id, d := range drains {
+		s := d.snapshot()
+		if s.FinishedAt != nil && time.Since(*s.FinishedAt) > drainRetention {
+			delete(drains, id)
+		}
+	}
+}
+
+// DrainNode cordons a node and evicts its pods in the background, honouring
+// PodDisruptionBudgets. It answers 202 with the drain id; poll GetDrainStatus for
+// progress. Options: force, ignoreDaemonSets (default true), deleteEmptyDirData,
+// gracePeriodSeconds and timeoutSeconds (default 600). Nodes running pods of a
+// protected namespace are not drained (409).
+func DrainNode(c *gin.Context) {
+	cs, name, ok := nodeRequest(c, models.VerbDelete)
+	if !ok {
+		return
+	}
+	cl, _ := clusterFor(c)
+
+	opts := drainOptions{
+		force:              c.Query("force") == "true",
+		ignoreDaemonSets:   c.DefaultQuery("ignoreDaemonSets", "true") == "true",
+		deleteEmptyDirData: c.Query("deleteEmptyDirData") == "true",
+		timeout:            drainDefaultTimeout,
+	}
+	if v := c.Query("gracePeriodSeconds"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "gracePeriodSeconds must be a non-negative integer"})
+			return
+		}
+		opts.gracePeriodSeconds = &n
+	}
+	if v := c.Query("timeoutSeconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timeoutSeconds must be a positive integer"})
+			return
+		}
+		opts.timeout = time.Duration(n) * time.Second
+	}
+	if opts.timeout > drainMaxTimeout {
+		opts.timeout = drainMaxTimeout
+	}
+
+	ctx := context.TODO()
+	node, err := cs.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	pods, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + name})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	toDrain, err := drainablePods(pods.Items, opts)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	// evicting pods of a protected namespace cannot wait for approval
+	checked := map[string]bool{}
+	for _, p := range toDrain {
+		if p.Status != drainPodPending || checked[p.Namespace] {
+			continue
+		}
+		if !requireUnprotected(c, p.Namespace) {
+			return
+		}
+		checked[p.Namespace] = true
+	}
+	if err := setUnschedulable(ctx, cs, auditFor(c), node, true); err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	drainsMu.Lock()
+	pruneDrains()
+	drainSeq++
+	if toDrain == nil {
+		toDrain = []drainPod{}
+	}
+	d := &drainOperation{status: drainStatus{
+		ID:        strconv.Itoa(drainSeq),
+		Cluster:   cl.name,
+		Node:      name,
+		User:      currentUser(c),
+		State:     drainRunning,
+		Pods:      toDrain,
+		StartedAt: time.Now(),
+	}}
+	drains[d.status.ID] = d
+	drainsMu.Unlock()
+
+	st := d.snapshot()
+	auditFor(c).detail = fmt.Sprintf("drain=%s pods=%d %s", st.ID, len(toDrain), c.Request.URL.RawQuery)
+	logrus.Infof("k8s: drain %s of node %s started by %s (%d pods)", st.ID, name, st.User, len(toDrain))
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+		defer cancel()
+		err := runDrain(ctx, cs, d, opts)
+		d.finish(err)
+		if err != nil {
+			logrus.Warnf("k8s: drain %s of node %s failed: %v", st.ID, name, err)
+			return
+		}
+		logrus.Infof("k8s: drain %s of node %s finished", st.ID, name)
+	}()
+
+	c.JSON(http.StatusAccepted, st)
+}
+
+// GetDrainStatus returns the progress of a drain started by DrainNode
+func GetDrainStatus(c *gin.Context) {
+	if !authorizeNamespace(c, models.AllNamespaces, models.VerbList) {
+		return
+	}
+	drainsMu.Lock()
+	d, ok := drains[c.Param("id")]
+	drainsMu.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "drain not found"})
+		return
+	}
+	c.JSON(http.StatusOK, d.snapshot())
+}
@@ -0,0 +1,383 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"gin-demo/models"
+)
+
+var (
+	overviewEventWindow  = time.Hour
+	overviewEventLimit   = 20
+	overviewHotspotLimit = 10
+)
+
+// namespaceSnapshot holds the objects of one namespace the overview is computed from.
+type namespaceSnapshot struct {
+	deployments  []*appsv1.Deployment
+	statefulsets []*appsv1.StatefulSet
+	daemonsets   []*appsv1.DaemonSet
+	jobs         []*batchv1.Job
+	cronjobs     []*batchv1.CronJob
+	services     []*corev1.Service
+	pods         []*corev1.Pod
+}
+
+func pointers[T any](items []T) []*T {
+	ps := make([]*T, len(items))
+	for i := range items {
+		ps[i] = &items[i]
+	}
+	return ps
+}
+
+func snapshotFromCache(rc *resourceCache, namespace string) (*namespaceSnapshot, error) {
+	var s namespaceSnapshot
+	var err error
+	if s.deployments, err = rc.deployments.Deployments(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	if s.statefulsets, err = rc.statefulsets.StatefulSets(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	if s.daemonsets, err = rc.daemonsets.DaemonSets(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	if s.jobs, err = rc.jobs.Jobs(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	if s.cronjobs, err = rc.cronjobs.CronJobs(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	if s.services, err = rc.services.Services(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	if s.pods, err = rc.pods.Pods(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func snapshotLive(ctx context.Context, cs kubernetes.Interface, namespace string) (*namespaceSnapshot, error) {
+	var s namespaceSnapshot
+	opts := metav1.ListOptions{}
+	deps, err := cs.AppsV1().Deployments(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.deployments = pointers(deps.Items)
+	stss, err := cs.AppsV1().StatefulSets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.statefulsets = pointers(stss.Items)
+	dss, err := cs.AppsV1().DaemonSets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.daemonsets = pointers(dss.Items)
+	jobs, err := cs.BatchV1().Jobs(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.jobs = pointers(jobs.Items)
+	cjs, err := cs.BatchV1().CronJobs(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.cronjobs = pointers(cjs.Items)
+	svcs, err := cs.CoreV1().Services(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.services = pointers(svcs.Items)
+	pods, err := cs.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.pods = pointers(pods.Items)
+	return &s, nil
+}
+
+// unhealthyObject names an object that needs attention and why.
+type unhealthyObject struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// kindHealth counts the objects of one kind.
+type kindHealth struct {
+	Total     int               `json:"total"`
+	Healthy   int               `json:"healthy"`
+	Unhealthy []unhealthyObject `json:"unhealthy"`
+}
+
+func (h *kindHealth) add(name string, healthy bool, reason string) {
+	h.Total++
+	if healthy {
+		h.Healthy++
+		return
+	}
+	h.Unhealthy = append(h.Unhealthy, unhealthyObject{Name: name, Reason: reason})
+}
+
+// podNotReady describes a pod that is neither ready nor completed.
+type podNotReady struct {
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	Reason   string `json:"reason"`
+	Node     string `json:"node"`
+	Restarts int32  `json:"restarts"`
+}
+
+// restartHotspot is a container that restarted.
+type restartHotspot struct {
+	Pod        string `json:"pod"`
+	Container  string `json:"container"`
+	Restarts   int32  `json:"restarts"`
+	LastReason string `json:"lastReason,omitempty"`
+}
+
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func deploymentHealth(d *appsv1.Deployment) (bool, string) {
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return false, "progress deadline exceeded"
+		}
+	}
+	desired := desiredReplicas(d.Spec.Replicas)
+	if d.Status.AvailableReplicas < desired {
+		return false, fmt.Sprintf("%d/%d available", d.Status.AvailableReplicas, desired)
+	}
+	return true, ""
+}
+
+func statefulSetHealth(sts *appsv1.StatefulSet) (bool, string) {
+	desired := desiredReplicas(sts.Spec.Replicas)
+	if sts.Status.ReadyReplicas < desired {
+		return false, fmt.Sprintf("%d/%d ready", sts.Status.ReadyReplicas, desired)
+	}
+	return true, ""
+}
+
+func daemonSetHealth(ds *appsv1.DaemonSet) (bool, string) {
+	if ds.Status.NumberReady < ds.Status.DesiredNumberScheduled {
+		return false, fmt.Sprintf("%d/%d ready", ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+	}
+	return true, ""
+}
+
+func jobHealth(j *batchv1.Job) (bool, string) {
+	for _, cond := range j.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			return false, "failed: " + cond.Reason
+		}
+	}
+	return true, ""
+}
+
+func cronJobHealth(cj *batchv1.CronJob) (bool, string) {
+	if cj.Spec.Suspend != nil && *cj.Spec.Suspend {
+		return false, "suspended"
+	}
+	return true, ""
+}
+
+// podReadiness reports whether a pod is ready (or completed) and otherwise the most
+// useful reason: a waiting container reason, an unschedulable condition or the phase.
+func podReadiness(p *corev1.Pod) (bool, string) {
+	if p.Status.Phase == corev1.PodSucceeded {
+		return true, ""
+	}
+	if p.Status.Phase == corev1.PodRunning {
+		for _, cond := range p.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				return true, ""
+			}
+		}
+	}
+	for _, statuses := range [][]corev1.ContainerStatus{p.Status.InitContainerStatuses, p.Status.ContainerStatuses} {
+		for _, cs := range statuses {
+			if w := cs.State.Waiting; w != nil && w.Reason != "" {
+				return false, w.Reason
+			}
+			if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+				return false, t.Reason
+			}
+		}
+	}
+	for _, cond := range p.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && cond.Reason != "" {
+			return false, cond.Reason
+		}
+	}
+	if p.Status.Reason != "" {
+		return false, p.Status.Reason
+	}
+	if p.Status.Phase == corev1.PodRunning {
+		return false, "ContainersNotReady"
+	}
+	return false, string(p.Status.Phase)
+}
+
+func podRestarts(p *corev1.Pod) int32 {
+	var n int32
+	for _, cs := range p.Status.ContainerStatuses {
+		n += cs.RestartCount
+	}
+	return n
+}
+
+// serviceHealth reports services whose selector matches no ready pod.
+func serviceHealth(svc *corev1.Service, readyPods []*corev1.Pod) (bool, string) {
+	if len(svc.Spec.Selector) == 0 || svc.Spec.Type == corev1.ServiceTypeExternalName {
+		return true, ""
+	}
+	selector := labels.SelectorFromSet(svc.Spec.Selector)
+	for _, p := range readyPods {
+		if selector.Matches(labels.Set(p.Labels)) {
+			return true, ""
+		}
+	}
+	return false, "no ready endpoints"
+}
+
+// GetNamespaceOverview returns the health of a namespace in one call: counts and
+// unhealthy objects per kind, pods that are not ready, containers with the most
+// restarts and the recent warning events.
+func GetNamespaceOverview(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.TODO()
+	var snap *namespaceSnapshot
+	var err error
+	source := sourceLive
+	if rc := cacheFor(c, cl); rc != nil {
+		snap, err = snapshotFromCache(rc, namespace)
+		source = sourceCache
+	} else {
+		snap, err = snapshotLive(ctx, cl.client, namespace)
+	}
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	workloads := map[string]*kindHealth{}
+	for _, k := range []string{"deployments", "statefulsets", "daemonsets", "jobs", "cronjobs", "services", "pods"} {
+		workloads[k] = &kindHealth{Unhealthy: []unhealthyObject{}}
+	}
+	for _, d := range sortByName(snap.deployments) {
+		ok, reason := deploymentHealth(d)
+		workloads["deployments"].add(d.Name, ok, reason)
+	}
+	for _, sts := range sortByName(snap.statefulsets) {
+		ok, reason := statefulSetHealth(sts)
+		workloads["statefulsets"].add(sts.Name, ok, reason)
+	}
+	for _, ds := range sortByName(snap.daemonsets) {
+		ok, reason := daemonSetHealth(ds)
+		workloads["daemonsets"].add(ds.Name, ok, reason)
+	}
+	for _, j := range sortByName(snap.jobs) {
+		ok, reason := jobHealth(j)
+		workloads["jobs"].add(j.Name, ok, reason)
+	}
+	for _, cj := range sortByName(snap.cronjobs) {
+		ok, reason := cronJobHealth(cj)
+		workloads["cronjobs"].add(cj.Name, ok, reason)
+	}
+
+	phases := map[string]int{}
+	notReady := []podNotReady{}
+	hotspots := []restartHotspot{}
+	var readyPods []*corev1.Pod
+	for _, p := range sortByName(snap.pods) {
+		phases[string(p.Status.Phase)]++
+		ready, reason := podReadiness(p)
+		workloads["pods"].add(p.Name, ready, reason)
+		if ready {
+			if p.Status.Phase == corev1.PodRunning {
+				readyPods = append(readyPods, p)
+			}
+		} else {
+			notReady = append(notReady, podNotReady{
+				Name:     p.Name,
+				Phase:    string(p.Status.Phase),
+				Reason:   reason,
+				Node:     p.Spec.NodeName,
+				Restarts: podRestarts(p),
+			})
+		}
+		for _, cs := range p.Status.ContainerStatuses {
+			if cs.RestartCount == 0 {
+				continue
+			}
+			h := restartHotspot{Pod: p.Name, Container: cs.Name, Restarts: cs.RestartCount}
+			if t := cs.LastTerminationState.Terminated; t != nil {
+				h.LastReason = t.Reason
+			}
+			hotspots = append(hotspots, h)
+		}
+	}
+	sort.SliceStable(hotspots, func(i, j int) bool { return hotspots[i].Restarts > hotspots[j].Restarts })
+	if len(hotspots) > overviewHotspotLimit {
+		hotspots = hotspots[:overviewHotspotLimit]
+	}
+	for _, svc := range sortByName(snap.services) {
+		ok, reason := serviceHealth(svc, readyPods)
+		workloads["services"].add(svc.Name, ok, reason)
+	}
+
+	res := gin.H{
+		"namespace":       namespace,
+		"workloads":       workloads,
+		"podPhases":       phases,
+		"podsNotReady":    notReady,
+		"restartHotspots": hotspots,
+		"source":          source,
+	}
+	// events are not cached; a failure here should not hide the rest of the overview
+	events, err := listEvents(ctx, cl.client, eventFilter{
+		namespace: namespace,
+		eventType: corev1.EventTypeWarning,
+		since:     time.Now().Add(-overviewEventWindow),
+		limit:     overviewEventLimit,
+	})
+	if err != nil {
+		res["warningEvents"] = []eventView{}
+		res["eventsError"] = err.Error()
+	} else {
+		res["warningEvents"] = events
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,423 @@
+package kubernetes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// registryConfigPath configures the OCI registry queried for image tags.
+const registryConfigPath = "conf/registry.ini"
+
+var (
+	defaultTagLimit = 50
+	maxTagLimit     = 1000
+	// tags are listed in pages of tagPageSize, at most tagMaxPages of them
+	tagPageSize = 1000
+	tagMaxPages = 10
+)
+
+// repositoryName is the repository name grammar of the distribution spec; names
+// that do not match could reach other registry paths.
+var repositoryName = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+var (
+	errRegistryUnauthorized = errors.New("registry rejected the credentials")
+	errRepositoryNotFound   = errors.New("repository not found in the registry")
+)
+
+// registryClient lists the tags of repositories in one OCI registry through the
+// distribution API (GET /v2/<name>/tags/list).
+type registryClient struct {
+	base     *url.URL
+	username string
+	password string
+	// tokenHosts are other hosts trusted with the credentials, for token services
+	// running apart from the registry
+	tokenHosts []string
+	http       *http.Client
+
+	mu sync.Mutex
+	// bearer tokens by scope, for registries answering 401 with a token challenge
+	tokens map[string]string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   *registryClient
+)
+
+func newRegistryClient(rawURL, username, password string) (*registryClient, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(strings.TrimRight(rawURL, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid registry url %q", rawURL)
+	}
+	return &registryClient{
+		base:     u,
+		username: username,
+		password: password,
+		http:     &http.Client{Timeout: 10 * time.Second},
+		tokens:   map[string]string{},
+	}, nil
+}
+
+// loadRegistryConfig reads url, username, password and token_hosts (comma separated)
+// from path; REGISTRY_URL, REGISTRY_USERNAME, REGISTRY_PASSWORD and
+// REGISTRY_TOKEN_HOSTS override them. It returns nil when no registry is configured.
+func loadRegistryConfig(path string) (*registryClient, error) {
+	vals := map[string]string{}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+				continue
+			}
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			vals[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for key, env := range map[string]string{"url": "REGISTRY_URL", "username": "REGISTRY_USERNAME",
+		"password": "REGISTRY_PASSWORD", "token_hosts": "REGISTRY_TOKEN_HOSTS"} {
+		if v := os.Getenv(env); v != "" {
+			vals[key] = v
+		}
+	}
+	if vals["url"] == "" {
+		return nil, nil
+	}
+	r, err := newRegistryClient(vals["url"], vals["username"], vals["password"])
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range strings.Split(vals["token_hosts"], ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			r.tokenHosts = append(r.tokenHosts, h)
+		}
+	}
+	return r, nil
+}
+
+func setRegistry(r *registryClient) {
+	registryMu.Lock()
+	registry = r
+	registryMu.Unlock()
+}
+
+func getRegistry() *registryClient {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry
+}
+
+// host is the registry host as written in image references.
+func (r *registryClient) host() string {
+	return r.base.Host
+}
+
+// trusted reports whether the credentials may be sent to u: the registry itself or
+// a configured token host, over the scheme of the registry so that an https registry
+// never has them sent in cleartext.
+func (r *registryClient) trusted(u *url.URL) bool {
+	if u.Scheme != r.base.Scheme {
+		return false
+	}
+	if u.Host == r.base.Host {
+		return true
+	}
+	for _, h := range r.tokenHosts {
+		if u.Host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// repository returns the repository of image in the registry. image may be a full
+// reference such as "registry.example.com/team/app:1.2" or just "team/app"; a
+// reference to another registry is an error.
+func (r *registryClient) repository(image string) (string, error) {
+	ref := image
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	if i := strings.Index(ref, "/"); i > 0 {
+		first := ref[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			if first != r.host() {
+				return "", fmt.Errorf("image %s is not in the configured registry %s", image, r.host())
+			}
+			ref = ref[i+1:]
+		}
+	}
+	if !repositoryName.MatchString(ref) {
+		return "", fmt.Errorf("invalid image %q", image)
+	}
+	return ref, nil
+}
+
+// tags lists the tags of repo, following the Link header of paginated answers.
+func (r *registryClient) tags(repo string) ([]string, error) {
+	next := fmt.Sprintf("%s/v2/%s/tags/list?n=%d", r.base, repo, tagPageSize)
+	var tags []string
+	for page := 0; next != "" && page < tagMaxPages; page++ {
+		resp, err := r.get(next, "repository:"+repo+":pull")
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		link := resp.Header.Get("Link")
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag list from registry: %v", err)
+		}
+		tags = append(tags, body.Tags...)
+		next = r.nextPage(link)
+	}
+	return tags, nil
+}
+
+// nextPage resolves the rel="next" target of a Link header against the registry.
+// Targets leaving the registry end the listing.
+func (r *registryClient) nextPage(link string) string {
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	u, err := r.base.Parse(link[start+1 : end])
+	if err != nil || u.Scheme != r.base.Scheme || u.Host != r.base.Host {
+		return ""
+	}
+	return u.String()
+}
+
+// get sends an authenticated GET. Registries answering 401 with a Bearer challenge
+// get a token for scope from their token service, which is then reused.
+func (r *registryClient) get(target, scope string) (*http.Response, error) {
+	resp, err := r.do(target, scope)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			return nil, errRegistryUnauthorized
+		}
+		if err := r.fetchToken(challenge, scope); err != nil {
+			return nil, err
+		}
+		if resp, err = r.do(target, scope); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return nil, errRegistryUnauthorized
+		case http.StatusNotFound:
+			return nil, errRepositoryNotFound
+		}
+		return nil, fmt.Errorf("registry answered %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func (r *registryClient) do(target, scope string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	token := r.tokens[scope]
+	r.mu.Unlock()
+	switch {
+	case !r.trusted(req.URL):
+		// no credentials for other hosts
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case r.username != "":
+		req.SetBasicAuth(r.username, r.password)
+	}
+	return r.http.Do(req)
+}
+
+// fetchToken gets a bearer token for scope from the realm of challenge. The
+// configured credentials are only sent to a trusted realm; others are asked
+// anonymously.
+func (r *registryClient) fetchToken(challenge, scope string) error {
+	params := parseChallenge(challenge[len("bearer "):])
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid registry auth challenge %q", challenge)
+	}
+	q := realm.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" && r.trusted(realm) {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errRegistryUnauthorized
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("invalid token from registry: %v", err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return errRegistryUnauthorized
+	}
+	r.mu.Lock()
+	r.tokens[scope] = token
+	r.mu.Unlock()
+	return nil
+}
+
+// parseChallenge parses the key="value" pairs of a WWW-Authenticate challenge.
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return params
+}
+
+// tagLess orders tags naturally, comparing runs of digits by value, so that 1.10
+// sorts after 1.9.
+func tagLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da > 0 && db > 0 {
+			na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[da:], b[db:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitPrefix(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+// GetImageTags lists the tags of an image repository in the configured registry for
+// autocompletion. Query: image (a reference, its tag is ignored), q (tag prefix) and
+// limit. Tags come in descending natural order, so the newest versions come first.
+func GetImageTags(c *gin.Context) {
+	r := getRegistry()
+	if r == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no image registry configured"})
+		return
+	}
+	image := c.Query("image")
+	if image == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image required"})
+		return
+	}
+	limit := defaultTagLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxTagLimit)
+	}
+	repo, err := r.repository(image)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tags, err := r.tags(repo)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errRepositoryNotFound) {
+			status = http.StatusNotFound
+		}
+		logrus.Warnf("k8s: tag listing of %s failed: %v", repo, err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	prefix := c.Query("q")
+	matched := []string{}
+	for _, t := range tags {
+		if strings.HasPrefix(t, prefix) {
+			matched = append(matched, t)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return tagLess(matched[j], matched[i]) })
+	total := len(matched)
+	if total > limit {
+		matched = matched[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{"registry": r.host(), "repository": repo, "tags": matched, "total": total})
+}
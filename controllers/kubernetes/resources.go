@@ -0,0 +1,313 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+
+	"gin-demo/models"
+)
+
+// coreGroup names the legacy API group ("") in generic resource URLs.
+const coreGroup = "core"
+
+var (
+	errResourceNotFound = errors.New("resource not found")
+	// secrets must not be readable in clear text through the generic browser
+	errResourceHidden = errors.New("resource is not available through the generic browser")
+)
+
+// apiResource is a discovered resource as returned by ListAPIResources.
+type apiResource struct {
+	Group      string   `json:"group"`
+	Version    string   `json:"version"`
+	Resource   string   `json:"resource"`
+	Kind       string   `json:"kind"`
+	Namespaced bool     `json:"namespaced"`
+	Verbs      []string `json:"verbs"`
+}
+
+// resolveResource looks up group/version/resource through discovery. The cached
+// discovery is refreshed once when the resource is unknown, e.g. a new CRD.
+func (cl *cluster) resolveResource(group, version, resource string) (schema.GroupVersionResource, metav1.APIResource, error) {
+	if group == coreGroup {
+		group = ""
+	}
+	gv := schema.GroupVersion{Group: group, Version: version}
+	gvr := gv.WithResource(resource)
+	if gvr == (schema.GroupVersionResource{Version: "v1", Resource: "secrets"}) {
+		return gvr, metav1.APIResource{}, errResourceHidden
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		list, err := cl.discovery.ServerResourcesForGroupVersion(gv.String())
+		if err == nil {
+			for _, r := range list.APIResources {
+				if r.Name == resource {
+					r.Group, r.Version = group, version
+					return gvr, r, nil
+				}
+			}
+		} else if !apierrors.IsNotFound(err) && !errors.Is(err, memory.ErrCacheNotFound) {
+			return gvr, metav1.APIResource{}, err
+		}
+		cl.discovery.Invalidate()
+	}
+	return gvr, metav1.APIResource{}, errResourceNotFound
+}
+
+// genericRequest resolves the resource in the URL, checks that it supports verb and
+// that the user holds grant in the ns query parameter. Cluster-scoped resources and
+// lists without ns need a grant on all namespaces; ns is ignored for cluster-scoped
+// resources.
+func genericRequest(c *gin.Context, verb, grant string) (dynamic.ResourceInterface, schema.GroupVersionKind, string, bool) {
+	cl, ok := clusterFor(c)
+	if !ok {
+		return nil, schema.GroupVersionKind{}, "", false
+	}
+	gvr, res, err := cl.resolveResource(c.Param("group"), c.Param("version"), c.Param("resource"))
+	switch {
+	case errors.Is(err, errResourceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, schema.GroupVersionKind{}, "", false
+	case errors.Is(err, errResourceHidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil, schema.GroupVersionKind{}, "", false
+	case err != nil:
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return nil, schema.GroupVersionKind{}, "", false
+	}
+	if !containsVerb(res.Verbs, verb) {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "resource does not support " + verb})
+		return nil, schema.GroupVersionKind{}, "", false
+	}
+
+	namespace := ""
+	if res.Namespaced {
+		namespace = c.Query("ns")
+		if namespace == "" && verb != "list" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+			return nil, schema.GroupVersionKind{}, "", false
+		}
+	}
+	scope := namespace
+	if scope == "" {
+		scope = models.AllNamespaces
+	}
+	if !authorizeNamespace(c, scope, grant) {
+		return nil, schema.GroupVersionKind{}, "", false
+	}
+
+	gvk := gvr.GroupVersion().WithKind(res.Kind)
+	if namespace != "" {
+		return cl.dynamic.Resource(gvr).Namespace(namespace), gvk, namespace, true
+	}
+	return cl.dynamic.Resource(gvr), gvk, "", true
+}
+
+func containsVerb(verbs []string, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// ListAPIResources returns the resources served by the cluster, preferred versions only
+func ListAPIResources(c *gin.Context) {
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+	lists, err := cl.discovery.ServerPreferredResources()
+	// failing aggregated APIs (e.g. a broken metrics-server) still return the other groups
+	var partial *discovery.ErrGroupDiscoveryFailed
+	if err != nil && !errors.As(err, &partial) {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	resources := []apiResource{}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		group := gv.Group
+		if group == "" {
+			group = coreGroup
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			resources = append(resources, apiResource{
+				Group:      group,
+				Version:    gv.Version,
+				Resource:   r.Name,
+				Kind:       r.Kind,
+				Namespaced: r.Namespaced,
+				Verbs:      r.Verbs,
+			})
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Group != resources[j].Group {
+			return resources[i].Group < resources[j].Group
+		}
+		return resources[i].Resource < resources[j].Resource
+	})
+
+	res := gin.H{"resources": resources}
+	if partial != nil {
+		failed := map[string]string{}
+		for gv, e := range partial.Groups {
+			failed[gv.String()] = e.Error()
+		}
+		res["failedGroups"] = failed
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// ListGenericResources lists any resource, in namespace ns or across all namespaces.
+// labelSelector and fieldSelector are passed to the apiserver.
+func ListGenericResources(c *gin.Context) {
+	ri, _, _, ok := genericRequest(c, "list", models.VerbList)
+	if !ok {
+		return
+	}
+	list, err := ri.List(context.TODO(), metav1.ListOptions{
+		LabelSelector: c.Query("labelSelector"),
+		FieldSelector: c.Query("fieldSelector"),
+	})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": list.Items, "resourceVersion": list.GetResourceVersion()})
+}
+
+// GetGenericResourceYAML returns YAML of any resource
+func GetGenericResourceYAML(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+		return
+	}
+	ri, _, _, ok := genericRequest(c, "get", models.VerbGet)
+	if !ok {
+		return
+	}
+	obj, err := ri.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	writeObject(c, obj.Object)
+}
+
+// UpdateGenericResource previews or applies YAML changes to any resource, see applyChange
+func UpdateGenericResource(c *gin.Context) {
+	name := c.Query("name")
+	yamlStr := c.PostForm("yaml")
+	if name == "" || yamlStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and yaml required"})
+		return
+	}
+	ri, gvk, namespace, ok := genericRequest(c, "patch", models.VerbUpdate)
+	if !ok {
+		return
+	}
+	resource := c.Param("group") + "/" + c.Param("version") + "/" + c.Param("resource")
+	applyChange(c, resource, dynamicKind{ri}, gvk, namespace, name, yamlStr, nil)
+}
+
+// summaryResources are listed by GetNamespaceResources. Only counts are returned for
+// the entries without items, so no configuration data leaks into the summary.
+var summaryResources = []struct {
+	key   string
+	gvr   schema.GroupVersionResource
+	items bool
+}{
+	{"deployments", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, true},
+	{"daemonsets", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, true},
+	{"statefulsets", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, true},
+	{"jobs", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, true},
+	{"cronjobs", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, true},
+	{"services", schema.GroupVersionResource{Version: "v1", Resource: "services"}, true},
+	{"pods", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true},
+	{"configmaps", schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, false},
+	{"secrets", schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, false},
+	{"persistentvolumeclaims", schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, false},
+	{"ingresses", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}, false},
+}
+
+// GetNamespaceResources returns a summary of a namespace: the workloads, services and
+// pods plus counts of other common resources. Resources that fail to list (e.g. not
+// served by the cluster) are reported under errors.
+func GetNamespaceResources(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+	cl, ok := clusterFor(c)
+	if !ok {
+		return
+	}
+
+	type result struct {
+		items []unstructured.Unstructured
+		err   error
+	}
+	results := make([]result, len(summaryResources))
+	var wg sync.WaitGroup
+	for i, sr := range summaryResources {
+		wg.Add(1)
+		go func(i int, gvr schema.GroupVersionResource) {
+			defer wg.Done()
+			list, err := cl.dynamic.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			results[i].items = list.Items
+		}(i, sr.gvr)
+	}
+	wg.Wait()
+
+	res := gin.H{"namespace": namespace}
+	counts := map[string]int{}
+	errs := map[string]string{}
+	for i, sr := range summaryResources {
+		r := results[i]
+		if r.err != nil {
+			errs[sr.key] = r.err.Error()
+			continue
+		}
+		counts[sr.key] = len(r.items)
+		if sr.items {
+			res[sr.key] = r.items
+		}
+	}
+	res["counts"] = counts
+	if len(errs) > 0 {
+		res["errors"] = errs
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -1,29 +1,200 @@
 package kubernetes
 
-import "github.com/gin-gonic/gin"
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"gin-demo/models"
+	"gin-demo/session"
+)
+
+// Option configures RegisterRoutes.
+type Option func(*routeOptions)
+
+type routeOptions struct {
+	clusters []*cluster
+	registry *registryClient
+	err      error
+}
+
+// WithClients serves the routes from cs and dyn, registered as cluster name, instead
+// of connecting to the clusters of the kubeconfig files. The first cluster given is
+// the default one. Tests pass the client-go fakes here.
+func WithClients(name string, cs kubernetes.Interface, dyn dynamic.Interface) Option {
+	return func(o *routeOptions) {
+		o.clusters = append(o.clusters, newClusterFromClients(name, cs, dyn))
+	}
+}
+
+// WithRegistry lists image tags from the OCI registry at rawURL instead of the one
+// configured in conf/registry.ini. username may be empty for anonymous access.
+func WithRegistry(rawURL, username, password string) Option {
+	return func(o *routeOptions) {
+		o.registry, o.err = newRegistryClient(rawURL, username, password)
+	}
+}
 
 // RegisterRoutes registers all kubernetes-related routes onto the provided RouterGroup.
-func RegisterRoutes(k8s *gin.RouterGroup) {
-	k8s.GET("/namespaces", GetNamespaces)
-	k8s.GET("/deployments", GetDeployments)
-	k8s.GET("/daemonsets", GetDaemonSets)
-	k8s.GET("/statefulsets", GetStatefulSets)
-	k8s.GET("/jobs", GetJobs)
-	k8s.GET("/cronjobs", GetCronJobs)
-	k8s.GET("/services", GetServices)
-	k8s.GET("/deployments/pods", GetPodsForDeployment)
-	k8s.GET("/daemonsets/pods", GetPodsForDaemonSet)
-	k8s.GET("/statefulsets/pods", GetPodsForStatefulSet)
-	k8s.GET("/deployments/yaml", GetDeploymentYAML)
-	k8s.POST("/deployments/update", UpdateDeployment)
-	k8s.GET("/daemonsets/yaml", GetDaemonSetYAML)
-	k8s.POST("/daemonsets/update", UpdateDaemonSet)
-	k8s.GET("/statefulsets/yaml", GetStatefulSetYAML)
-	k8s.POST("/statefulsets/update", UpdateStatefulSet)
-	k8s.GET("/jobs/yaml", GetJobYAML)
-	k8s.POST("/jobs/update", UpdateJob)
-	k8s.GET("/cronjobs/yaml", GetCronJobYAML)
-	k8s.POST("/cronjobs/update", UpdateCronJob)
-	k8s.GET("/services/yaml", GetServiceYAML)
-	k8s.POST("/services/update", UpdateService)
-}
\ No newline at end of file
+// The user is put into the context by session.GlobalAuthMiddleware; each route declares
+// the permission it needs. Every route accepts an optional "cluster" query parameter
+// selecting a registered cluster (default cluster when omitted).
+// Clusters are connected in the background; resource routes answer 503 until then.
+func RegisterRoutes(k8s *gin.RouterGroup, opts ...Option) {
+	var o routeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.clusters) == 0 {
+		start()
+	} else {
+		for i, cl := range o.clusters {
+			registerCluster(cl, i == 0)
+		}
+		setState(StateReady, nil, time.Time{})
+	}
+	if o.err != nil {
+		logrus.Errorf("k8s: %v", o.err)
+	} else if o.registry == nil {
+		if r, err := loadRegistryConfig(registryConfigPath); err != nil {
+			logrus.Errorf("k8s: loading %s failed: %v", registryConfigPath, err)
+		} else {
+			o.registry = r
+		}
+	}
+	setRegistry(o.registry)
+
+	read := session.PermissionRequired(models.PermK8sRead)
+	write := session.PermissionRequired(models.PermK8sWrite)
+	manageClusters := session.PermissionRequired(models.PermK8sClusters)
+	exec := session.PermissionRequired(models.PermK8sExec)
+	audit := session.PermissionRequired(models.PermK8sAudit)
+	approve := session.PermissionRequired(models.PermK8sApprove)
+	protect := session.PermissionRequired(models.PermK8sProtect)
+
+	// every request that is not a read is written to the audit log
+	k8s = k8s.Group("", auditMutations(k8s.BasePath()))
+
+	k8s.GET("/audit", audit, GetAuditLogs)
+	k8s.GET("/clusters", read, ListClusters)
+	k8s.POST("/clusters", manageClusters, AddCluster)
+	k8s.DELETE("/clusters/:name", manageClusters, RemoveCluster)
+
+	// updates in protected namespaces become change requests applied on approval
+	k8s.GET("/protected", read, ListProtectedNamespaces)
+	k8s.POST("/protected", protect, ProtectNamespace)
+	k8s.DELETE("/protected/:id", protect, UnprotectNamespace)
+	k8s.GET("/changes", read, ListChanges)
+	k8s.POST("/changes/:id/approve", approve, ApproveChange)
+	k8s.POST("/changes/:id/reject", approve, RejectChange)
+	k8s.POST("/changes/:id/withdraw", write, WithdrawChange)
+
+	// tag autocompletion from the configured image registry
+	k8s.GET("/registry/tags", read, GetImageTags)
+
+	k8s = k8s.Group("", requireAvailable())
+
+	k8s.GET("/namespaces", read, GetNamespaces)
+	k8s.GET("/deployments", read, GetDeployments)
+	k8s.GET("/daemonsets", read, GetDaemonSets)
+	k8s.GET("/statefulsets", read, GetStatefulSets)
+	k8s.GET("/jobs", read, GetJobs)
+	k8s.GET("/cronjobs", read, GetCronJobs)
+	k8s.GET("/services", read, GetServices)
+	k8s.GET("/deployments/pods", read, GetPodsForDeployment)
+	k8s.GET("/daemonsets/pods", read, GetPodsForDaemonSet)
+	k8s.GET("/statefulsets/pods", read, GetPodsForStatefulSet)
+	k8s.GET("/deployments/yaml", read, GetDeploymentYAML)
+	k8s.POST("/deployments/update", write, UpdateDeployment)
+	k8s.GET("/daemonsets/yaml", read, GetDaemonSetYAML)
+	k8s.POST("/daemonsets/update", write, UpdateDaemonSet)
+	k8s.GET("/statefulsets/yaml", read, GetStatefulSetYAML)
+	k8s.POST("/statefulsets/update", write, UpdateStatefulSet)
+	k8s.GET("/jobs/yaml", read, GetJobYAML)
+	k8s.POST("/jobs/update", write, UpdateJob)
+	k8s.GET("/cronjobs/yaml", read, GetCronJobYAML)
+	k8s.POST("/cronjobs/update", write, UpdateCronJob)
+	k8s.GET("/services/yaml", read, GetServiceYAML)
+	k8s.POST("/services/update", write, UpdateService)
+	k8s.GET("/configmaps", read, GetConfigMaps)
+	k8s.GET("/configmaps/yaml", read, GetConfigMapYAML)
+	k8s.POST("/configmaps/update", write, UpdateConfigMap)
+	k8s.POST("/configmaps/create", write, CreateConfigMap)
+	// secret values are masked unless reveal=true is requested with k8s:secrets
+	k8s.GET("/secrets", read, GetSecrets)
+	k8s.GET("/secrets/yaml", read, GetSecretYAML)
+	k8s.POST("/secrets/update", write, UpdateSecret)
+	k8s.POST("/secrets/create", write, CreateSecret)
+	k8s.GET("/ingresses", read, GetIngresses)
+	k8s.GET("/ingresses/yaml", read, GetIngressYAML)
+	k8s.POST("/ingresses/update", write, UpdateIngress)
+	k8s.GET("/persistentvolumeclaims", read, GetPersistentVolumeClaims)
+	k8s.GET("/persistentvolumeclaims/yaml", read, GetPersistentVolumeClaimYAML)
+	k8s.POST("/persistentvolumeclaims/update", write, UpdatePersistentVolumeClaim)
+	k8s.GET("/horizontalpodautoscalers", read, GetHorizontalPodAutoscalers)
+	k8s.GET("/horizontalpodautoscalers/yaml", read, GetHorizontalPodAutoscalerYAML)
+	k8s.POST("/horizontalpodautoscalers/update", write, UpdateHorizontalPodAutoscaler)
+	k8s.GET("/networkpolicies", read, GetNetworkPolicies)
+	k8s.GET("/networkpolicies/yaml", read, GetNetworkPolicyYAML)
+	k8s.POST("/networkpolicies/update", write, UpdateNetworkPolicy)
+
+	// workload actions
+	k8s.POST("/deployments/scale", write, ScaleDeployment)
+	k8s.POST("/statefulsets/scale", write, ScaleStatefulSet)
+	k8s.POST("/deployments/restart", write, RestartDeployment)
+	k8s.POST("/daemonsets/restart", write, RestartDaemonSet)
+	k8s.POST("/statefulsets/restart", write, RestartStatefulSet)
+	k8s.POST("/deployments/pause", write, PauseDeployment)
+	k8s.POST("/deployments/resume", write, ResumeDeployment)
+	k8s.POST("/deployments/image", write, SetDeploymentImage)
+	k8s.POST("/statefulsets/image", write, SetStatefulSetImage)
+	k8s.POST("/daemonsets/image", write, SetDaemonSetImage)
+	k8s.POST("/cronjobs/image", write, SetCronJobImage)
+	k8s.GET("/deployments/rollout", read, GetDeploymentRolloutStatus)
+	k8s.GET("/daemonsets/rollout", read, GetDaemonSetRolloutStatus)
+	k8s.GET("/statefulsets/rollout", read, GetStatefulSetRolloutStatus)
+	k8s.GET("/deployments/history", read, GetDeploymentHistory)
+	k8s.POST("/deployments/rollback", write, RollbackDeployment)
+	k8s.POST("/cronjobs/run", write, RunCronJob)
+	k8s.POST("/cronjobs/suspend", write, SuspendCronJob)
+	k8s.POST("/cronjobs/resume", write, ResumeCronJob)
+	k8s.POST("/cronjobs/ttl", write, SetCronJobTTL)
+	k8s.POST("/jobs/rerun", write, RerunJob)
+	k8s.POST("/jobs/cleanup", write, CleanupJobs)
+
+	// create from a multi-document manifest; delete any typed kind
+	k8s.POST("/manifests", write, CreateFromManifest)
+	for kind := range typedKinds {
+		k8s.DELETE("/"+kind, write, deleteResource(kind))
+	}
+
+	// generic resources through discovery and the dynamic client; group "core" is the legacy group
+	k8s.GET("/apiresources", read, ListAPIResources)
+	k8s.GET("/resources", read, GetNamespaceResources)
+	k8s.GET("/overview", read, GetNamespaceOverview)
+	k8s.GET("/events", read, GetEvents)
+	k8s.GET("/resources/:group/:version/:resource", read, ListGenericResources)
+	k8s.GET("/resources/:group/:version/:resource/yaml", read, GetGenericResourceYAML)
+	k8s.POST("/resources/:group/:version/:resource/update", write, UpdateGenericResource)
+
+	// nodes are cluster-scoped and need a grant on "*"; drains run in the background
+	k8s.GET("/nodes", read, GetNodes)
+	k8s.POST("/nodes/cordon", write, CordonNode)
+	k8s.POST("/nodes/uncordon", write, UncordonNode)
+	k8s.POST("/nodes/drain", write, DrainNode)
+	k8s.GET("/nodes/drain/:id", read, GetDrainStatus)
+
+	// usage from metrics-server; metricsAvailable is false when it is not installed
+	k8s.GET("/top/nodes", read, TopNodes)
+	k8s.GET("/top/pods", read, TopPods)
+
+	k8s.GET("/pods/logs", read, GetPodLogs)
+	// WebSocket exec terminal, audited
+	k8s.GET("/pods/exec", exec, ExecPod)
+
+	// Server-Sent Events stream of ADDED/MODIFIED/DELETED events
+	k8s.GET("/watch/:kind", read, WatchResources)
+}
@@ -0,0 +1,83 @@
+package kubernetes
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kjson "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// object formats of the Get*YAML handlers
+const (
+	formatYAML = "yaml"
+	formatJSON = "json"
+)
+
+var (
+	yamlSerializer = kjson.NewSerializerWithOptions(kjson.DefaultMetaFactory, scheme.Scheme, scheme.Scheme,
+		kjson.SerializerOptions{Yaml: true})
+	jsonSerializer = kjson.NewSerializerWithOptions(kjson.DefaultMetaFactory, scheme.Scheme, scheme.Scheme,
+		kjson.SerializerOptions{Pretty: true})
+)
+
+// strippableFields are the fields strip= may remove, by their query name.
+var strippableFields = map[string][]string{
+	"managedFields": {"metadata", "managedFields"},
+	"status":        {"status"},
+}
+
+// objectOutput is how a request wants objects written.
+type objectOutput struct {
+	serializer  runtime.Encoder
+	contentType string
+	strip       [][]string
+}
+
+// parseObjectOutput reads format (yaml or json, default yaml) and strip, a comma
+// separated list of managedFields and status; it answers 400 on bad values.
+func parseObjectOutput(c *gin.Context) (objectOutput, bool) {
+	out := objectOutput{serializer: yamlSerializer, contentType: "application/yaml"}
+	switch c.DefaultQuery("format", formatYAML) {
+	case formatYAML:
+	case formatJSON:
+		out.serializer, out.contentType = jsonSerializer, "application/json"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be yaml or json"})
+		return out, false
+	}
+	if v := c.Query("strip"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			path, ok := strippableFields[strings.TrimSpace(name)]
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "strip accepts managedFields and status"})
+				return out, false
+			}
+			out.strip = append(out.strip, path)
+		}
+	}
+	return out, true
+}
+
+// writeObject writes an object map, as built by toObjectMap, in the format the request
+// asks for through the apimachinery serializer, so the output reads back into Update*.
+func writeObject(c *gin.Context, obj map[string]interface{}) {
+	out, ok := parseObjectOutput(c)
+	if !ok {
+		return
+	}
+	u := &unstructured.Unstructured{Object: obj}
+	for _, path := range out.strip {
+		unstructured.RemoveNestedField(u.Object, path...)
+	}
+	var buf bytes.Buffer
+	if err := out.serializer.Encode(u, &buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, out.contentType, buf.Bytes())
+}
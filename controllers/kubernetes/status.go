@@ -0,0 +1,144 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// States of the kubernetes subsystem reported by Health.
+const (
+	StateStarting    = "starting"
+	StateReady       = "ready"
+	StateUnavailable = "unavailable"
+)
+
+var (
+	minRetryInterval = 5 * time.Second
+	maxRetryInterval = 1 * time.Minute
+)
+
+var (
+	stateMu     sync.RWMutex
+	state       = StateStarting
+	stateErr    string
+	lastAttempt time.Time
+	nextAttempt time.Time
+	startOnce   sync.Once
+)
+
+// HealthStatus describes the kubernetes subsystem for the /health endpoint.
+type HealthStatus struct {
+	State       string    `json:"state"`
+	Error       string    `json:"error,omitempty"`
+	Clusters    int       `json:"clusters"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+}
+
+// Health returns the current state of the kubernetes subsystem.
+func Health() HealthStatus {
+	stateMu.RLock()
+	h := HealthStatus{State: state, Error: stateErr, LastAttempt: lastAttempt}
+	if state != StateReady {
+		h.NextAttempt = nextAttempt
+	}
+	stateMu.RUnlock()
+	clustersMu.RLock()
+	h.Clusters = len(clusters)
+	clustersMu.RUnlock()
+	return h
+}
+
+func setState(s string, err error, next time.Time) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	state = s
+	stateErr = ""
+	if err != nil {
+		stateErr = err.Error()
+	}
+	lastAttempt = time.Now()
+	nextAttempt = next
+}
+
+// start launches the background connector once. The rest of the application keeps
+// working while kubernetes is unavailable.
+func start() {
+	startOnce.Do(func() { go connectLoop() })
+}
+
+// connect loads the kubeconfig files and checks that the default cluster answers.
+func connect() error {
+	if err := loadFileClusters(); err != nil {
+		logrus.Warnf("k8s: load kubeconfig failed: %v", err)
+		clustersMu.RLock()
+		n := len(clusters)
+		clustersMu.RUnlock()
+		if n == 0 {
+			return err
+		}
+	}
+	cl, ok := getCluster("")
+	if !ok {
+		return fmt.Errorf("no default cluster configured")
+	}
+	if _, err := cl.probe(); err != nil {
+		return fmt.Errorf("cluster %s unreachable: %w", cl.name, err)
+	}
+	return nil
+}
+
+// connectLoop retries connect with exponential backoff until it succeeds.
+func connectLoop() {
+	wait := minRetryInterval
+	for {
+		err := connect()
+		if err == nil {
+			setState(StateReady, nil, time.Time{})
+			logrus.Infof("k8s: connected")
+			return
+		}
+		setState(StateUnavailable, err, time.Now().Add(wait))
+		logrus.Warnf("k8s: unavailable, retrying in %s: %v", wait, err)
+		time.Sleep(wait)
+		wait *= 2
+		if wait > maxRetryInterval {
+			wait = maxRetryInterval
+		}
+	}
+}
+
+// requireAvailable rejects requests with a structured 503 while the kubernetes
+// subsystem is not ready. Requests naming an explicitly registered cluster are let through.
+func requireAvailable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := Health()
+		if h.State == StateReady {
+			c.Next()
+			return
+		}
+		if name := c.Query("cluster"); name != "" {
+			if _, ok := getCluster(name); ok {
+				c.Next()
+				return
+			}
+		}
+		retry := int(time.Until(h.NextAttempt).Seconds()) + 1
+		if retry < 1 {
+			retry = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retry))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":       "kubernetes unavailable",
+			"state":       h.State,
+			"reason":      h.Error,
+			"retry_after": retry,
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"gin-demo/models"
+)
+
+// pvcView summarises a persistent volume claim.
+type pvcView struct {
+	Name         string   `json:"name"`
+	Namespace    string   `json:"namespace"`
+	Status       string   `json:"status"`
+	Volume       string   `json:"volume,omitempty"`
+	Capacity     string   `json:"capacity,omitempty"`
+	Requested    string   `json:"requested,omitempty"`
+	AccessModes  []string `json:"accessModes"`
+	StorageClass string   `json:"storageClass,omitempty"`
+	VolumeMode   string   `json:"volumeMode,omitempty"`
+	// Resizing is set while a volume expansion is in progress
+	Resizing  bool      `json:"resizing"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newPVCView(pvc *corev1.PersistentVolumeClaim) pvcView {
+	v := pvcView{
+		Name:        pvc.Name,
+		Namespace:   pvc.Namespace,
+		Status:      string(pvc.Status.Phase),
+		Volume:      pvc.Spec.VolumeName,
+		Capacity:    quantityString(pvc.Status.Capacity, corev1.ResourceStorage),
+		Requested:   quantityString(pvc.Spec.Resources.Requests, corev1.ResourceStorage),
+		AccessModes: []string{},
+		CreatedAt:   pvc.CreationTimestamp.Time,
+	}
+	modes := pvc.Status.AccessModes
+	if len(modes) == 0 {
+		modes = pvc.Spec.AccessModes
+	}
+	for _, m := range modes {
+		v.AccessModes = append(v.AccessModes, string(m))
+	}
+	if pvc.Spec.StorageClassName != nil {
+		v.StorageClass = *pvc.Spec.StorageClassName
+	}
+	if pvc.Spec.VolumeMode != nil {
+		v.VolumeMode = string(*pvc.Spec.VolumeMode)
+	}
+	for _, cond := range pvc.Status.Conditions {
+		if (cond.Type == corev1.PersistentVolumeClaimResizing || cond.Type == corev1.PersistentVolumeClaimFileSystemResizePending) &&
+			cond.Status == corev1.ConditionTrue {
+			v.Resizing = true
+		}
+	}
+	return v
+}
+
+// GetPersistentVolumeClaims returns the PVCs of a namespace with the bound volume,
+// capacity and storage class
+func GetPersistentVolumeClaims(c *gin.Context) {
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	pvcs, err := cs.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	views := make([]pvcView, 0, len(pvcs.Items))
+	for i := range pvcs.Items {
+		views = append(views, newPVCView(&pvcs.Items[i]))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	c.JSON(http.StatusOK, gin.H{"persistentvolumeclaims": views, "source": sourceLive})
+}
+
+// GetPersistentVolumeClaimYAML returns YAML of a PVC
+func GetPersistentVolumeClaimYAML(c *gin.Context) {
+	cs, namespace, name, ok := workloadRequest(c, models.VerbGet)
+	if !ok {
+		return
+	}
+	pvc, err := cs.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	obj, err := toObjectMap(pvc, "persistentvolumeclaims")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeObject(c, obj)
+}
+
+// UpdatePersistentVolumeClaim previews or applies YAML changes to a PVC, e.g. a larger
+// storage request, see applyYAML
+func UpdatePersistentVolumeClaim(c *gin.Context) {
+	applyYAML(c, "persistentvolumeclaims")
+}
@@ -0,0 +1,159 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"gin-demo/models"
+)
+
+var (
+	watchHeartbeat = 15 * time.Second
+	// watchReconnect is the retry delay suggested to EventSource clients
+	watchReconnect = 3 * time.Second
+)
+
+type watchFunc func(ctx context.Context, cs kubernetes.Interface, namespace string, opts metav1.ListOptions) (watch.Interface, error)
+
+// watchFuncs maps the kinds served by /watch/:kind to their typed watch calls.
+var watchFuncs = map[string]watchFunc{
+	"deployments": func(ctx context.Context, cs kubernetes.Interface, ns string, opts metav1.ListOptions) (watch.Interface, error) {
+		return cs.AppsV1().Deployments(ns).Watch(ctx, opts)
+	},
+	"daemonsets": func(ctx context.Context, cs kubernetes.Interface, ns string, opts metav1.ListOptions) (watch.Interface, error) {
+		return cs.AppsV1().DaemonSets(ns).Watch(ctx, opts)
+	},
+	"statefulsets": func(ctx context.Context, cs kubernetes.Interface, ns string, opts metav1.ListOptions) (watch.Interface, error) {
+		return cs.AppsV1().StatefulSets(ns).Watch(ctx, opts)
+	},
+	"jobs": func(ctx context.Context, cs kubernetes.Interface, ns string, opts metav1.ListOptions) (watch.Interface, error) {
+		return cs.BatchV1().Jobs(ns).Watch(ctx, opts)
+	},
+	"cronjobs": func(ctx context.Context, cs kubernetes.Interface, ns string, opts metav1.ListOptions) (watch.Interface, error) {
+		return cs.BatchV1().CronJobs(ns).Watch(ctx, opts)
+	},
+	"services": func(ctx context.Context, cs kubernetes.Interface, ns string, opts metav1.ListOptions) (watch.Interface, error) {
+		return cs.CoreV1().Services(ns).Watch(ctx, opts)
+	},
+	"pods": func(ctx context.Context, cs kubernetes.Interface, ns string, opts metav1.ListOptions) (watch.Interface, error) {
+		return cs.CoreV1().Pods(ns).Watch(ctx, opts)
+	},
+}
+
+// WatchResources streams ADDED/MODIFIED/DELETED events of a kind in a namespace as
+// Server-Sent Events. Each event id is the object's resourceVersion, so a reconnecting
+// EventSource resumes through Last-Event-ID; resourceVersion may also be passed as a
+// query parameter. An "expired" event tells the client its version is too old and it
+// must reload the list and reconnect without one.
+func WatchResources(c *gin.Context) {
+	kind := c.Param("kind")
+	namespace := c.Query("ns")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "namespace required"})
+		return
+	}
+	wf, ok := watchFuncs[kind]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unsupported kind", "kind": kind})
+		return
+	}
+	if !authorizeNamespace(c, namespace, models.VerbList) {
+		return
+	}
+
+	cs, ok := clientFor(c)
+	if !ok {
+		return
+	}
+
+	rv := c.Query("resourceVersion")
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		rv = id
+	}
+	opts := metav1.ListOptions{LabelSelector: c.Query("labelSelector"), AllowWatchBookmarks: true}
+
+	ctx := c.Request.Context()
+	opts.ResourceVersion = rv
+	w, err := wf(ctx, cs, namespace, opts)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+			status = http.StatusGone
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	send := func(ev sse.Event) {
+		_ = sse.Encode(c.Writer, ev)
+		c.Writer.Flush()
+	}
+	send(sse.Event{Event: "ready", Retry: uint(watchReconnect.Milliseconds()), Data: gin.H{"kind": kind, "namespace": namespace, "resourceVersion": rv}})
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	defer func() { w.Stop() }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			send(sse.Event{Event: "heartbeat", Data: time.Now().Unix()})
+		case ev, open := <-w.ResultChan():
+			if !open {
+				// the apiserver closes watches periodically; resume from the last version
+				opts.ResourceVersion = rv
+				w, err = wf(ctx, cs, namespace, opts)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					logrus.Warnf("k8s: rewatch %s/%s from %s failed: %v", namespace, kind, rv, err)
+					if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+						send(sse.Event{Event: "expired", Data: gin.H{"resourceVersion": rv}})
+					} else {
+						send(sse.Event{Event: "error", Data: gin.H{"error": err.Error()}})
+					}
+					return
+				}
+				continue
+			}
+			switch ev.Type {
+			case watch.Bookmark:
+				if obj, err := meta.Accessor(ev.Object); err == nil {
+					rv = obj.GetResourceVersion()
+				}
+			case watch.Error:
+				status := apierrors.FromObject(ev.Object)
+				if apierrors.IsGone(status) || apierrors.IsResourceExpired(status) {
+					send(sse.Event{Event: "expired", Data: gin.H{"resourceVersion": rv}})
+				} else {
+					send(sse.Event{Event: "error", Data: gin.H{"error": status.Error()}})
+				}
+				return
+			default:
+				obj, err := meta.Accessor(ev.Object)
+				if err != nil {
+					continue
+				}
+				rv = obj.GetResourceVersion()
+				send(sse.Event{Id: rv, Event: string(ev.Type), Data: ev.Object})
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package users
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"gin-demo/models"
+)
+
+// ListGrants handles GET /users/admin/grants
+func ListGrants(c *gin.Context) {
+	gs, err := models.ListNamespaceGrants()
+	if err != nil {
+		logrus.Errorf("grants: list failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"grants": gs})
+}
+
+// CreateGrant handles POST /users/admin/grants
+func CreateGrant(c *gin.Context) {
+	type req struct {
+		SubjectKind string   `json:"subject_kind" binding:"required"`
+		Subject     string   `json:"subject" binding:"required"`
+		Cluster     string   `json:"cluster"`
+		Namespace   string   `json:"namespace" binding:"required"`
+		Verbs       []string `json:"verbs" binding:"required"`
+	}
+	var r req
+	if err := c.ShouldBindJSON(&r); err != nil {
+		logrus.Warnf("grants: create bad request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	g, err := models.CreateNamespaceGrant(r.SubjectKind, r.Subject, r.Cluster, r.Namespace, r.Verbs)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidGrant) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "verbs": models.AllVerbs})
+			return
+		}
+		logrus.Errorf("grants: create failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	admin, _ := c.Get("user")
+	logrus.Infof("grants: %v granted %s:%s %s on namespace %s in cluster %s", admin, g.SubjectKind, g.Subject, g.Verbs, g.Namespace, g.Cluster)
+	c.JSON(http.StatusCreated, gin.H{"grant": g})
+}
+
+// DeleteGrant handles DELETE /users/admin/grants/:id
+func DeleteGrant(c *gin.Context) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := models.DeleteNamespaceGrant(uint(id64)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		logrus.Errorf("grants: delete failed id=%v err=%v", id64, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
@@ -0,0 +1,80 @@
+package users
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"gin-demo/models"
+)
+
+// ListRoles handles GET /users/admin/roles
+func ListRoles(c *gin.Context) {
+	rs, err := models.ListRoles()
+	if err != nil {
+		logrus.Errorf("roles: list failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	type item struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	res := make([]item, 0, len(rs))
+	for _, r := range rs {
+		perms := make([]string, 0, len(r.Permissions))
+		for _, p := range r.Permissions {
+			perms = append(perms, p.Name)
+		}
+		res = append(res, item{Name: r.Name, Description: r.Description, Permissions: perms})
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": res})
+}
+
+// GetUserRoles handles GET /users/admin/users/:username/roles
+func GetUserRoles(c *gin.Context) {
+	username := c.Param("username")
+	roles, err := models.UserRoles(username)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		logrus.Errorf("roles: get user roles failed user=%s err=%v", username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"username": username, "roles": roles})
+}
+
+// SetUserRoles handles PUT /users/admin/users/:username/roles
+func SetUserRoles(c *gin.Context) {
+	type req struct {
+		Roles []string `json:"roles"`
+	}
+	var r req
+	if err := c.ShouldBindJSON(&r); err != nil {
+		logrus.Warnf("roles: set bad request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	username := c.Param("username")
+	if err := models.SetUserRoles(username, r.Roles); err != nil {
+		switch {
+		case errors.Is(err, models.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		case errors.Is(err, models.ErrRoleNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		default:
+			logrus.Errorf("roles: set failed user=%s err=%v", username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		}
+		return
+	}
+	admin, _ := c.Get("user")
+	logrus.Infof("roles: %v set roles of %s to %v", admin, username, r.Roles)
+	c.JSON(http.StatusOK, gin.H{"username": username, "roles": r.Roles})
+}
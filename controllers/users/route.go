@@ -1,6 +1,11 @@
 package users
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+
+	"gin-demo/models"
+	"gin-demo/session"
+)
 
 // RegisterRoutes registers all user-related routes onto the provided RouterGroup.
 func RegisterRoutes(users *gin.RouterGroup) {
@@ -13,4 +18,14 @@ func RegisterRoutes(users *gin.RouterGroup) {
 	users.POST("/register", Register)
 	users.POST("/login", Login)
 	users.POST("/logout", Logout)
+
+	// role administration requires the users:admin permission
+	admin := users.Group("/admin", session.AuthRequired(), session.PermissionRequired(models.PermUsersAdmin))
+	admin.GET("/roles", ListRoles)
+	admin.GET("/users/:username/roles", GetUserRoles)
+	admin.PUT("/users/:username/roles", SetUserRoles)
+	// per-namespace kubernetes grants
+	admin.GET("/grants", ListGrants)
+	admin.POST("/grants", CreateGrant)
+	admin.DELETE("/grants/:id", DeleteGrant)
 }
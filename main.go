@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	k8sCtrl "gin-demo/controllers/kubernetes"
 	"gin-demo/logger"
 	"gin-demo/models"
 	"gin-demo/routes"
@@ -51,10 +52,20 @@ func main() {
 			panic(err)
 		}
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Article{}, &models.Label{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Article{}, &models.Label{}, &models.Permission{}, &models.Role{}, &models.NamespaceGrant{}, &models.Cluster{}, &models.AuditLog{}, &models.ProtectedNamespace{}, &models.ChangeRequest{}); err != nil {
 		panic(err)
 	}
 	models.InitDB(db)
+	// INITIAL_ADMIN names the user promoted to admin when no user holds the role
+	models.InitialAdmin = os.Getenv("INITIAL_ADMIN")
+	// seed built-in roles/permissions used by session.PermissionRequired
+	if err := models.SeedRoles(); err != nil {
+		panic(err)
+	}
+	// register kubernetes clusters added through the admin API
+	if err := k8sCtrl.LoadStoredClusters(); err != nil {
+		fmt.Printf("warning: failed to load stored clusters: %v\n", err)
+	}
 
 	// serve static frontend files
 	r.Static("/static", "./static")
@@ -70,7 +81,15 @@ func main() {
 		port = "8080"
 	}
 
-	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
+	r.GET("/health", func(c *gin.Context) {
+		// the kubernetes subsystem may be degraded without affecting the rest of the app
+		k8s := k8sCtrl.Health()
+		status := "ok"
+		if k8s.State != k8sCtrl.StateReady {
+			status = "degraded"
+		}
+		c.JSON(http.StatusOK, gin.H{"status": status, "kubernetes": k8s})
+	})
 
 	if err := r.Run(":" + port); err != nil {
 		panic(err)
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditLog records an action a user performed against a kubernetes cluster.
+type AuditLog struct {
+	gorm.Model
+	User      string `gorm:"size:64;not null;index" json:"user"`
+	Cluster   string `gorm:"size:64;index" json:"cluster"`
+	Namespace string `gorm:"size:253;index" json:"namespace"`
+	Kind      string `gorm:"size:64;index" json:"kind"`
+	Name      string `gorm:"size:253" json:"name"`
+	Action    string `gorm:"size:32;not null;index" json:"action"`
+	Result    string `gorm:"size:255" json:"result"`
+	// Status is the HTTP status of the request, 0 for entries not tied to one
+	Status   int    `json:"status"`
+	ClientIP string `gorm:"size:64" json:"client_ip"`
+	// BeforeHash and AfterHash are sha256 hashes of the object before and after a change
+	BeforeHash string `gorm:"size:64" json:"before_hash"`
+	AfterHash  string `gorm:"size:64" json:"after_hash"`
+	// Detail holds action specific information such as the exec command
+	Detail     string     `gorm:"type:text" json:"detail"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// TableName returns the DB table name.
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+// CreateAuditLog stores an audit entry.
+func CreateAuditLog(a *AuditLog) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	return DB.Create(a).Error
+}
+
+// FinishAuditLog sets the result of a long running action such as an exec session.
+func FinishAuditLog(id uint, result, detail string) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	now := time.Now()
+	return DB.Model(&AuditLog{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"result":      result,
+		"detail":      detail,
+		"finished_at": &now,
+	}).Error
+}
+
+// AuditFilter selects audit entries; empty fields match everything.
+type AuditFilter struct {
+	User      string
+	Cluster   string
+	Namespace string
+	Kind      string
+	Name      string
+	Action    string
+	// Failed selects entries whose result is an error when true, the others when false
+	Failed *bool
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+// ListAuditLogs returns the entries matching f, newest first, and the total number
+// of matches ignoring Offset and Limit.
+func ListAuditLogs(f AuditFilter) ([]AuditLog, int64, error) {
+	if DB == nil {
+		return nil, 0, gorm.ErrInvalidDB
+	}
+	q := DB.Model(&AuditLog{})
+	for column, value := range map[string]string{
+		"user":      f.User,
+		"cluster":   f.Cluster,
+		"namespace": f.Namespace,
+		"kind":      f.Kind,
+		"name":      f.Name,
+		"action":    f.Action,
+	} {
+		if value != "" {
+			q = q.Where(column+" = ?", strings.TrimSpace(value))
+		}
+	}
+	if f.Failed != nil {
+		if *f.Failed {
+			q = q.Where("result LIKE ?", "error%")
+		} else {
+			q = q.Where("result NOT LIKE ?", "error%")
+		}
+	}
+	if !f.Since.IsZero() {
+		q = q.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		q = q.Where("created_at < ?", f.Until)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []AuditLog
+	if err := q.Order("id desc").Offset(f.Offset).Limit(f.Limit).Find(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
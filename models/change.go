@@ -0,0 +1,201 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Change request states. A request is claimed as approved before it is applied and
+// then ends as applied, conflict (the object changed meanwhile) or failed. Pending
+// requests may also be rejected by an approver or withdrawn by their requester.
+const (
+	ChangePending   = "pending"
+	ChangeApproved  = "approved"
+	ChangeApplied   = "applied"
+	ChangeRejected  = "rejected"
+	ChangeWithdrawn = "withdrawn"
+	ChangeConflict  = "conflict"
+	ChangeFailed    = "failed"
+)
+
+var (
+	ErrProtectedNamespaceExists = errors.New("namespace is already protected")
+	ErrChangeNotPending         = errors.New("change request is not pending")
+)
+
+// ProtectedNamespace marks a namespace whose updates need approval. Cluster
+// AllNamespaces ("*") protects the namespace in every cluster.
+type ProtectedNamespace struct {
+	gorm.Model
+	Cluster   string `gorm:"size:64;not null;uniqueIndex:idx_protected_ns" json:"cluster"`
+	Namespace string `gorm:"size:253;not null;uniqueIndex:idx_protected_ns" json:"namespace"`
+}
+
+// TableName returns the DB table name.
+func (ProtectedNamespace) TableName() string {
+	return "protected_namespaces"
+}
+
+// ChangeRequest is an update to an object in a protected namespace waiting for approval.
+type ChangeRequest struct {
+	gorm.Model
+	Cluster   string `gorm:"size:64;not null;index" json:"cluster"`
+	Namespace string `gorm:"size:253;not null;index" json:"namespace"`
+	// Resource is a typed kind such as "deployments" or "group/version/resource"
+	Resource   string `gorm:"size:255;not null" json:"resource"`
+	APIVersion string `gorm:"size:128;not null" json:"apiVersion"`
+	Kind       string `gorm:"size:64;not null" json:"kind"`
+	Name       string `gorm:"size:253;not null" json:"name"`
+	Requester  string `gorm:"size:64;not null;index" json:"requester"`
+	// YAML is the proposed object and Diff the JSON diff shown when it was requested
+	YAML string `gorm:"type:text;not null" json:"yaml"`
+	Diff string `gorm:"type:text" json:"diff"`
+	// PatchType is set when YAML holds a patch of that type rather than the whole
+	// object, e.g. application/strategic-merge-patch+json
+	PatchType string `gorm:"size:64" json:"patchType"`
+	// ResourceVersion of the live object when the change was requested
+	ResourceVersion string     `gorm:"size:64" json:"resourceVersion"`
+	Force           bool       `json:"force"`
+	Status          string     `gorm:"size:16;not null;index" json:"status"`
+	Approver        string     `gorm:"size:64" json:"approver"`
+	Comment         string     `gorm:"size:1024" json:"comment"`
+	Result          string     `gorm:"size:1024" json:"result"`
+	DecidedAt       *time.Time `json:"decidedAt"`
+}
+
+// TableName returns the DB table name.
+func (ChangeRequest) TableName() string {
+	return "change_requests"
+}
+
+// CreateProtectedNamespace protects namespace in cluster.
+func CreateProtectedNamespace(cluster, namespace string) (*ProtectedNamespace, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var existing ProtectedNamespace
+	if err := DB.Where("cluster = ? AND namespace = ?", cluster, namespace).First(&existing).Error; err == nil {
+		return nil, ErrProtectedNamespaceExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	p := &ProtectedNamespace{Cluster: cluster, Namespace: namespace}
+	if err := DB.Create(p).Error; err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListProtectedNamespaces returns all protected namespaces ordered by cluster.
+func ListProtectedNamespaces() ([]ProtectedNamespace, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var ps []ProtectedNamespace
+	if err := DB.Order("cluster asc, namespace asc").Find(&ps).Error; err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// DeleteProtectedNamespace permanently removes a protection by ID.
+func DeleteProtectedNamespace(id uint) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	res := DB.Unscoped().Delete(&ProtectedNamespace{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// NamespaceProtected reports whether updates to namespace in cluster need approval.
+func NamespaceProtected(cluster, namespace string) (bool, error) {
+	if DB == nil {
+		return false, gorm.ErrInvalidDB
+	}
+	var n int64
+	err := DB.Model(&ProtectedNamespace{}).
+		Where("namespace = ? AND cluster IN ?", namespace, []string{cluster, AllNamespaces}).
+		Count(&n).Error
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// CreateChangeRequest stores a new pending change request.
+func CreateChangeRequest(cr *ChangeRequest) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	cr.Status = ChangePending
+	return DB.Create(cr).Error
+}
+
+// GetChangeRequest returns a change request by ID.
+func GetChangeRequest(id uint) (*ChangeRequest, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var cr ChangeRequest
+	if err := DB.First(&cr, id).Error; err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
+
+// ListChangeRequests returns change requests newest first, optionally only those
+// with status.
+func ListChangeRequests(status string) ([]ChangeRequest, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	q := DB.Order("id desc")
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var crs []ChangeRequest
+	if err := q.Find(&crs).Error; err != nil {
+		return nil, err
+	}
+	return crs, nil
+}
+
+// DecideChangeRequest moves a pending change request to status (approved or
+// rejected). It fails with ErrChangeNotPending when someone else decided first.
+func DecideChangeRequest(cr *ChangeRequest, status, approver, comment string) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	now := time.Now()
+	res := DB.Model(&ChangeRequest{}).
+		Where("id = ? AND status = ?", cr.ID, ChangePending).
+		Updates(map[string]interface{}{"status": status, "approver": approver, "comment": comment, "decided_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrChangeNotPending
+	}
+	cr.Status, cr.Approver, cr.Comment, cr.DecidedAt = status, approver, comment, &now
+	return nil
+}
+
+// FinishChangeRequest records the outcome of applying an approved change request.
+func FinishChangeRequest(cr *ChangeRequest, status, result string) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	if len(result) > 1024 {
+		result = result[:1024]
+	}
+	cr.Status, cr.Result = status, result
+	return DB.Model(cr).Updates(map[string]interface{}{"status": status, "result": result}).Error
+}
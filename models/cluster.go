@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrClusterExists   = errors.New("cluster already exists")
+	ErrClusterNotFound = errors.New("cluster not found")
+)
+
+// Cluster is a kubernetes cluster registered through the admin API.
+// Clusters configured by kubeconfig files under conf/ are not stored here.
+type Cluster struct {
+	gorm.Model
+	Name string `gorm:"size:64;uniqueIndex;not null" json:"name"`
+	// Context selects a context inside Kubeconfig; empty uses its current-context
+	Context    string `gorm:"size:128" json:"context"`
+	Kubeconfig string `gorm:"type:text;not null" json:"-"`
+}
+
+// TableName returns the DB table name.
+func (Cluster) TableName() string {
+	return "clusters"
+}
+
+// CreateCluster stores a new cluster kubeconfig.
+func CreateCluster(name, context, kubeconfig string) (*Cluster, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var existing Cluster
+	if err := DB.Where("name = ?", name).First(&existing).Error; err == nil {
+		return nil, ErrClusterExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	cl := &Cluster{Name: name, Context: context, Kubeconfig: kubeconfig}
+	if err := DB.Create(cl).Error; err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// ListClusters returns all stored clusters ordered by name.
+func ListClusters() ([]Cluster, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var cls []Cluster
+	if err := DB.Order("name asc").Find(&cls).Error; err != nil {
+		return nil, err
+	}
+	return cls, nil
+}
+
+// DeleteCluster permanently removes a stored cluster by name.
+func DeleteCluster(name string) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	res := DB.Unscoped().Where("name = ?", name).Delete(&Cluster{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrClusterNotFound
+	}
+	return nil
+}
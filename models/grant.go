@@ -0,0 +1,169 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Kubernetes verbs that can be granted per namespace.
+const (
+	VerbList   = "list"
+	VerbGet    = "get"
+	VerbCreate = "create"
+	VerbUpdate = "update"
+	VerbScale  = "scale"
+	VerbDelete = "delete"
+	VerbExec   = "exec"
+)
+
+// Subject kinds of a namespace grant. A group grant applies to every user
+// holding the role with the same name.
+const (
+	SubjectUser  = "user"
+	SubjectGroup = "group"
+)
+
+// AllNamespaces is the wildcard cluster, namespace and verb of a grant.
+const AllNamespaces = "*"
+
+// AllVerbs lists every verb a grant may contain.
+var AllVerbs = []string{VerbList, VerbGet, VerbCreate, VerbUpdate, VerbScale, VerbDelete, VerbExec}
+
+var ErrInvalidGrant = errors.New("invalid namespace grant")
+
+// NamespaceGrant allows a user or group to perform verbs inside a kubernetes namespace
+// of one registered cluster, or of every cluster when Cluster is AllNamespaces.
+type NamespaceGrant struct {
+	gorm.Model
+	SubjectKind string `gorm:"size:16;not null;index:idx_grant_subject" json:"subject_kind"`
+	Subject     string `gorm:"size:64;not null;index:idx_grant_subject" json:"subject"`
+	Cluster     string `gorm:"size:64;not null;default:'*';index" json:"cluster"`
+	Namespace   string `gorm:"size:253;not null;index" json:"namespace"`
+	// Verbs stores comma-separated verbs, "*" for all
+	Verbs string `gorm:"size:255;not null" json:"verbs"`
+}
+
+// TableName returns the DB table name.
+func (NamespaceGrant) TableName() string {
+	return "namespace_grants"
+}
+
+// Allows reports whether the grant covers verb in namespace of cluster.
+func (g NamespaceGrant) Allows(cluster, namespace, verb string) bool {
+	if g.Cluster != AllNamespaces && g.Cluster != cluster {
+		return false
+	}
+	if g.Namespace != AllNamespaces && g.Namespace != namespace {
+		return false
+	}
+	for _, v := range strings.Split(g.Verbs, ",") {
+		if v == AllNamespaces || v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateNamespaceGrant validates and stores a new grant. An empty cluster grants
+// namespace in every cluster.
+func CreateNamespaceGrant(kind, subject, cluster, namespace string, verbs []string) (*NamespaceGrant, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	if (kind != SubjectUser && kind != SubjectGroup) || subject == "" || namespace == "" || len(verbs) == 0 {
+		return nil, ErrInvalidGrant
+	}
+	for _, v := range verbs {
+		if v != AllNamespaces && !containsString(AllVerbs, v) {
+			return nil, ErrInvalidGrant
+		}
+	}
+	if cluster == "" {
+		cluster = AllNamespaces
+	}
+	g := &NamespaceGrant{SubjectKind: kind, Subject: subject, Cluster: cluster, Namespace: namespace, Verbs: strings.Join(verbs, ",")}
+	if err := DB.Create(g).Error; err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// ListNamespaceGrants returns all grants ordered by subject.
+func ListNamespaceGrants() ([]NamespaceGrant, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var gs []NamespaceGrant
+	if err := DB.Order("subject_kind asc, subject asc, cluster asc, namespace asc").Find(&gs).Error; err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// DeleteNamespaceGrant removes a grant by ID.
+func DeleteNamespaceGrant(id uint) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	res := DB.Delete(&NamespaceGrant{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UserNamespaceGrants returns the grants that apply to a user directly or through
+// its roles. Users holding users:admin get a single wildcard grant.
+func UserNamespaceGrants(username string) ([]NamespaceGrant, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	isAdmin, err := UserHasPermission(username, PermUsersAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin {
+		return []NamespaceGrant{{SubjectKind: SubjectUser, Subject: username, Cluster: AllNamespaces, Namespace: AllNamespaces, Verbs: AllNamespaces}}, nil
+	}
+	roles, err := UserRoles(username)
+	if err != nil {
+		return nil, err
+	}
+	var gs []NamespaceGrant
+	q := DB.Where("subject_kind = ? AND subject = ?", SubjectUser, username)
+	if len(roles) > 0 {
+		q = q.Or("subject_kind = ? AND subject IN ?", SubjectGroup, roles)
+	}
+	if err := q.Find(&gs).Error; err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// NamespaceAllowed reports whether the user may perform verb in namespace of cluster.
+func NamespaceAllowed(username, cluster, namespace, verb string) (bool, error) {
+	gs, err := UserNamespaceGrants(username)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range gs {
+		if g.Allows(cluster, namespace, verb) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
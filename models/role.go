@@ -0,0 +1,261 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Permission names checked by session.PermissionRequired.
+const (
+	PermUsersAdmin    = "users:admin"
+	PermArticlesWrite = "articles:write"
+	PermK8sRead       = "k8s:read"
+	PermK8sWrite      = "k8s:write"
+	PermK8sClusters   = "k8s:clusters"
+	PermK8sExec       = "k8s:exec"
+	// PermK8sSecrets allows revealing secret values; they are masked otherwise
+	PermK8sSecrets = "k8s:secrets"
+	// PermK8sAudit allows reading the kubernetes audit log
+	PermK8sAudit = "k8s:audit"
+	// PermK8sApprove allows approving changes to protected namespaces
+	PermK8sApprove = "k8s:approve"
+	// PermK8sProtect allows protecting namespaces and lifting their protection
+	PermK8sProtect = "k8s:protect"
+)
+
+// Built-in role names seeded by SeedRoles.
+const (
+	RoleAdmin       = "admin"
+	RoleEditor      = "editor"
+	RoleViewer      = "viewer"
+	RoleK8sOperator = "k8s-operator"
+)
+
+// AllPermissions lists every permission known to the application.
+var AllPermissions = []string{PermUsersAdmin, PermArticlesWrite, PermK8sRead, PermK8sWrite, PermK8sClusters, PermK8sExec, PermK8sSecrets, PermK8sAudit, PermK8sApprove, PermK8sProtect}
+
+// DefaultRole is assigned to newly registered users.
+var DefaultRole = RoleEditor
+
+var ErrRoleNotFound = errors.New("role not found")
+
+// Permission is a named capability that can be granted to roles.
+type Permission struct {
+	gorm.Model
+	Name string `gorm:"size:64;uniqueIndex;not null" json:"name"`
+}
+
+// Role groups permissions and is assigned to users.
+type Role struct {
+	gorm.Model
+	Name        string       `gorm:"size:64;uniqueIndex;not null" json:"name"`
+	Description string       `gorm:"size:255" json:"description"`
+	Permissions []Permission `gorm:"many2many:role_permissions;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"permissions"`
+}
+
+// TableName returns the DB table name.
+func (Permission) TableName() string {
+	return "permissions"
+}
+
+// TableName returns the DB table name.
+func (Role) TableName() string {
+	return "roles"
+}
+
+var builtinRoles = []struct {
+	Name        string
+	Description string
+	Permissions []string
+}{
+	{RoleAdmin, "full access including user and role management", AllPermissions},
+	{RoleEditor, "can write articles", []string{PermArticlesWrite}},
+	{RoleViewer, "read-only access to the kubernetes dashboard", []string{PermK8sRead}},
+	{RoleK8sOperator, "can view and change kubernetes resources", []string{PermK8sRead, PermK8sWrite}},
+}
+
+// InitialAdmin names the user made admin on start when no user holds the admin role,
+// as after upgrading an installation that predates roles. The oldest user is
+// promoted when it is empty or names no user.
+var InitialAdmin string
+
+// SeedRoles creates the built-in permissions and roles if missing and gives
+// users without any role the default role. It is safe to call on every start.
+// Permissions are only granted to a built-in role when the role or the permission
+// is new, so permissions an admin removed stay removed.
+func SeedRoles() error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	perms := map[string]Permission{}
+	newPerms := map[string]bool{}
+	for _, name := range AllPermissions {
+		var p Permission
+		res := DB.Where(Permission{Name: name}).FirstOrCreate(&p)
+		if res.Error != nil {
+			return res.Error
+		}
+		perms[name] = p
+		newPerms[name] = res.RowsAffected > 0
+	}
+	for _, br := range builtinRoles {
+		var r Role
+		res := DB.Where(Role{Name: br.Name}).Attrs(Role{Description: br.Description}).FirstOrCreate(&r)
+		if res.Error != nil {
+			return res.Error
+		}
+		var ps []Permission
+		for _, name := range br.Permissions {
+			if res.RowsAffected > 0 || newPerms[name] {
+				ps = append(ps, perms[name])
+			}
+		}
+		if len(ps) == 0 {
+			continue
+		}
+		if err := DB.Model(&r).Association("Permissions").Append(ps); err != nil {
+			return err
+		}
+	}
+
+	// backfill users created before roles existed
+	var users []User
+	if err := DB.Where("id NOT IN (SELECT user_id FROM user_roles)").Find(&users).Error; err != nil {
+		return err
+	}
+	for i := range users {
+		if err := SetUserRoles(users[i].Username, []string{DefaultRole}); err != nil {
+			return err
+		}
+	}
+	return ensureAdmin()
+}
+
+// ensureAdmin gives the admin role to InitialAdmin, or else the oldest user, when no
+// user holds it. Installations without users are left to the first registration.
+func ensureAdmin() error {
+	var admins int64
+	err := DB.Model(&User{}).
+		Joins("JOIN user_roles ur ON ur.user_id = users.id").
+		Joins("JOIN roles r ON r.id = ur.role_id").
+		Where("r.name = ?", RoleAdmin).
+		Count(&admins).Error
+	if err != nil || admins > 0 {
+		return err
+	}
+	var u User
+	err = gorm.ErrRecordNotFound
+	if InitialAdmin != "" {
+		err = DB.Where("username = ?", InitialAdmin).First(&u).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = DB.Order("id asc").First(&u).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var admin Role
+	if err := DB.Where("name = ?", RoleAdmin).First(&admin).Error; err != nil {
+		return err
+	}
+	return DB.Model(&u).Association("Roles").Append(&admin)
+}
+
+// ListRoles returns all roles with their permissions ordered by name.
+func ListRoles() ([]Role, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var rs []Role
+	if err := DB.Preload("Permissions").Order("name asc").Find(&rs).Error; err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// UserRoles returns the role names assigned to a user.
+func UserRoles(username string) ([]string, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var u User
+	if err := DB.Preload("Roles").Where("username = ?", username).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	names := make([]string, 0, len(u.Roles))
+	for _, r := range u.Roles {
+		names = append(names, r.Name)
+	}
+	return names, nil
+}
+
+// SetUserRoles replaces the roles of a user with the named roles.
+func SetUserRoles(username string, roles []string) error {
+	if DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	return setUserRoles(DB, username, roles)
+}
+
+func setUserRoles(db *gorm.DB, username string, roles []string) error {
+	var u User
+	if err := db.Where("username = ?", username).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	var rs []Role
+	if len(roles) > 0 {
+		if err := db.Where("name IN ?", roles).Find(&rs).Error; err != nil {
+			return err
+		}
+		if len(rs) != len(roles) {
+			return ErrRoleNotFound
+		}
+	}
+	return db.Model(&u).Association("Roles").Replace(rs)
+}
+
+// UserHasPermission reports whether any role of the user grants perm.
+func UserHasPermission(username, perm string) (bool, error) {
+	if DB == nil {
+		return false, gorm.ErrInvalidDB
+	}
+	var n int64
+	err := DB.Table("permissions").
+		Joins("JOIN role_permissions rp ON rp.permission_id = permissions.id").
+		Joins("JOIN user_roles ur ON ur.role_id = rp.role_id").
+		Joins("JOIN users u ON u.id = ur.user_id").
+		Where("u.username = ? AND u.deleted_at IS NULL AND permissions.name = ?", username, perm).
+		Count(&n).Error
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// UsersWithPermission returns the users any of whose roles grant perm.
+func UsersWithPermission(perm string) ([]User, error) {
+	if DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var users []User
+	err := DB.Distinct("users.*").
+		Joins("JOIN user_roles ur ON ur.user_id = users.id").
+		Joins("JOIN role_permissions rp ON rp.role_id = ur.role_id").
+		Joins("JOIN permissions p ON p.id = rp.permission_id").
+		Where("p.name = ?", perm).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
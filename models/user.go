@@ -5,6 +5,7 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type User struct {
@@ -12,6 +13,8 @@ type User struct {
 	Username string `gorm:"uniqueIndex;size:64;not null"`
 	Email    string `gorm:"uniqueIndex;size:128;not null"`
 	Password string `gorm:"column:password;not null"`
+	// Roles many-to-many relationship used for permission checks
+	Roles []Role `gorm:"many2many:user_roles;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
 var (
@@ -40,11 +43,23 @@ func CreateUser(username, email, password string) error {
 	if err != nil {
 		return err
 	}
-	u = User{Username: username, Email: email, Password: string(hash)}
-	if err := DB.Create(&u).Error; err != nil {
-		return err
-	}
-	return nil
+	// the very first account bootstraps the installation and becomes admin; the
+	// count locks the users table so parallel registrations cannot both see zero
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&User{}).Clauses(clause.Locking{Strength: "UPDATE"}).Count(&count).Error; err != nil {
+			return err
+		}
+		roles := []string{DefaultRole}
+		if count == 0 {
+			roles = append(roles, RoleAdmin)
+		}
+		u = User{Username: username, Email: email, Password: string(hash)}
+		if err := tx.Create(&u).Error; err != nil {
+			return err
+		}
+		return setUserRoles(tx, username, roles)
+	})
 }
 
 func Authenticate(username, password string) error {
@@ -68,3 +83,18 @@ func Authenticate(username, password string) error {
 func (User) TableName() string {
 	return "users"
 }
+
+// UserEmail returns the email address of a user.
+func UserEmail(username string) (string, error) {
+	if DB == nil {
+		return "", gorm.ErrInvalidDB
+	}
+	var u User
+	if err := DB.Where("username = ?", username).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	return u.Email, nil
+}
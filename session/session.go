@@ -16,6 +16,7 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"gin-demo/auth"
+	"gin-demo/models"
 
 	"github.com/sirupsen/logrus"
 )
@@ -204,6 +205,31 @@ func AuthRequired() gin.HandlerFunc {
 	}
 }
 
+// PermissionRequired is a Gin middleware that only lets the request through when the
+// user stored in the context (by AuthRequired or GlobalAuthMiddleware) holds perm.
+func PermissionRequired(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userI, ok := c.Get("user")
+		username, _ := userI.(string)
+		if !ok || username == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		allowed, err := models.UserHasPermission(username, perm)
+		if err != nil {
+			logrus.Errorf("PermissionRequired: lookup failed user=%s perm=%s err=%v", username, perm, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+			return
+		}
+		if !allowed {
+			logrus.Warnf("PermissionRequired: denied user=%s perm=%s path=%s", username, perm, c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "permission": perm})
+			return
+		}
+		c.Next()
+	}
+}
+
 // GlobalAuthMiddleware enforces login for all non-user pages.
 // It skips paths under /users, /static, /health, and /articles and redirects browser GETs to /users/to_login.
 func GlobalAuthMiddleware() gin.HandlerFunc {
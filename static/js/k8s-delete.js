@@ -0,0 +1,22 @@
+// k8sDelete asks for the propagation policy and a typed confirmation of the name,
+// then calls DELETE /api/k8s/<kind>?ns=&name=&confirm=&propagationPolicy=.
+(function(){
+  function remove(kind, ns, name, onDone){
+    var typed = prompt('删除 ' + kind + '/' + name + '（命名空间 ' + ns + '）\n请输入资源名称以确认：');
+    if(typed === null) return;
+    if(typed !== name){ alert('名称不匹配，已取消删除'); return }
+    var policy = prompt('级联策略：background（默认）、foreground 或 orphan', 'background');
+    if(policy === null) return;
+    var url = '/api/k8s/' + kind + '?ns=' + encodeURIComponent(ns) + '&name=' + encodeURIComponent(name) +
+      '&confirm=' + encodeURIComponent(typed) + '&propagationPolicy=' + encodeURIComponent(policy);
+    fetch(url, {method: 'DELETE'})
+      .then(function(resp){ return resp.json().then(function(data){ return {ok: resp.ok, data: data} }) })
+      .then(function(r){
+        if(!r.ok){ alert('删除失败：' + (r.data.error || '')); return }
+        if(onDone) onDone();
+      })
+      .catch(function(err){ alert('删除失败：' + err.message) });
+  }
+
+  window.k8sDelete = remove;
+})();
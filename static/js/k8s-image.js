@@ -0,0 +1,89 @@
+// k8sSetImage opens a small dialog to change the image of one container of a
+// workload. Tags of the configured registry are offered for autocompletion; the
+// change is sent to POST /api/k8s/<kind>/image?ns=&name=&container=&image=.
+(function(){
+  function escapeHTML(s){
+    return String(s || '').replace(/[&<>"]/g, function(ch){ return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;'}[ch] });
+  }
+
+  function podSpec(obj){
+    if(obj.kind === 'CronJob') return obj.spec.jobTemplate.spec.template.spec;
+    return obj.spec.template.spec;
+  }
+
+  function repoOf(image){
+    var i = image.lastIndexOf(':');
+    return i > image.lastIndexOf('/') ? image.substring(0, i) : image.split('@')[0];
+  }
+
+  function loadTags(image, list){
+    list.innerHTML = '';
+    fetch('/api/k8s/registry/tags?limit=200&image=' + encodeURIComponent(image))
+      .then(function(resp){ return resp.json() })
+      .then(function(data){
+        if(!data.tags) return;
+        var repo = repoOf(image);
+        list.innerHTML = data.tags.map(function(t){ return '<option value="' + escapeHTML(repo + ':' + t) + '">' }).join('');
+      })
+      .catch(function(){});
+  }
+
+  function open(kind, ns, name, onDone){
+    var url = '/api/k8s/' + kind + '/yaml?format=json&strip=managedFields,status&ns=' + encodeURIComponent(ns) + '&name=' + encodeURIComponent(name);
+    fetch(url)
+      .then(function(resp){ return resp.json() })
+      .then(function(obj){
+        if(obj.error){ alert('加载失败：' + obj.error); return }
+        var spec = podSpec(obj);
+        var containers = (spec.initContainers || []).concat(spec.containers || []);
+        show(kind, ns, name, containers, onDone);
+      })
+      .catch(function(err){ alert('加载失败：' + err.message) });
+  }
+
+  function show(kind, ns, name, containers, onDone){
+    var old = document.getElementById('k8sImageDialog');
+    if(old) old.remove();
+    var dlg = document.createElement('div');
+    dlg.id = 'k8sImageDialog';
+    dlg.style.cssText = 'position:fixed;top:20%;left:50%;transform:translateX(-50%);background:#fff;border:1px solid #ccc;padding:16px;box-shadow:0 2px 8px rgba(0,0,0,.3);z-index:1000;min-width:420px';
+    dlg.innerHTML = '<h3>更新镜像：' + escapeHTML(kind + '/' + name) + '</h3>' +
+      '<div><label>容器 <select id="k8sImageContainer">' +
+      containers.map(function(c){ return '<option value="' + escapeHTML(c.name) + '">' + escapeHTML(c.name) + '</option>' }).join('') +
+      '</select></label></div>' +
+      '<div><input id="k8sImageValue" list="k8sImageTags" style="width:100%;box-sizing:border-box" placeholder="镜像"></div>' +
+      '<datalist id="k8sImageTags"></datalist>' +
+      '<div><button id="k8sImageSave">更新</button> <button id="k8sImageCancel">取消</button></div>';
+    document.body.appendChild(dlg);
+
+    var select = document.getElementById('k8sImageContainer');
+    var input = document.getElementById('k8sImageValue');
+    var list = document.getElementById('k8sImageTags');
+    function pick(){
+      var c = containers.filter(function(c){ return c.name === select.value })[0];
+      input.value = c ? c.image : '';
+      if(input.value) loadTags(input.value, list);
+    }
+    select.onchange = pick;
+    pick();
+
+    document.getElementById('k8sImageCancel').onclick = function(){ dlg.remove() };
+    document.getElementById('k8sImageSave').onclick = function(){
+      var image = input.value.trim();
+      if(!image) return;
+      var target = '/api/k8s/' + kind + '/image?ns=' + encodeURIComponent(ns) + '&name=' + encodeURIComponent(name) +
+        '&container=' + encodeURIComponent(select.value) + '&image=' + encodeURIComponent(image);
+      fetch(target, {method: 'POST'})
+        .then(function(resp){ return resp.json().then(function(data){ return {status: resp.status, data: data} }) })
+        .then(function(r){
+          if(r.status === 202){ alert('命名空间受保护，变更已提交审批 (#' + r.data.changeRequest.ID + ')') }
+          else if(r.status !== 200){ alert('更新失败：' + (r.data.error || r.status)); return }
+          dlg.remove();
+          if(onDone) onDone();
+        })
+        .catch(function(err){ alert('更新失败：' + err.message) });
+    };
+  }
+
+  window.k8sSetImage = open;
+})();
@@ -0,0 +1,31 @@
+// k8sWatch follows /api/k8s/watch/<kind>?ns=<ns> with an EventSource and calls
+// onChange (debounced) whenever an object is added, modified or deleted.
+// Calling it again with another namespace closes the previous stream.
+(function(){
+  var current = null;
+
+  function follow(kind, ns, onChange){
+    if(current && current.kind === kind && current.ns === ns) return;
+    stop();
+    var timer = null;
+    var es = new EventSource('/api/k8s/watch/' + kind + '?ns=' + encodeURIComponent(ns));
+    function changed(){
+      clearTimeout(timer);
+      timer = setTimeout(onChange, 500);
+    }
+    ['ADDED', 'MODIFIED', 'DELETED'].forEach(function(t){ es.addEventListener(t, changed) });
+    // our resourceVersion is too old: reload the list and start over
+    es.addEventListener('expired', function(){
+      stop();
+      onChange();
+      follow(kind, ns, onChange);
+    });
+    current = {kind: kind, ns: ns, es: es};
+  }
+
+  function stop(){
+    if(current){ current.es.close(); current = null }
+  }
+
+  window.k8sWatch = {follow: follow, stop: stop};
+})();